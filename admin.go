@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleGetAccountsCreated lists accounts by creation date. created_from is
+// inclusive and created_to exclusive; either may be left out. status=pending
+// lists the accounts waiting for approval.
+func (s *ApiServer) handleGetAccountsCreated(w http.ResponseWriter, r *http.Request) error {
+	from, err := getTimeParam(r, "created_from", time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := getTimeParam(r, "created_to", time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("created_from must be before created_to")
+	}
+
+	limit, offset, err := getPagination(r)
+	if err != nil {
+		return err
+	}
+	fields, err := getFields(r, accountFields)
+	if err != nil {
+		return err
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", AccountStatusActive, AccountStatusPending, AccountStatusRejected:
+	default:
+		return fmt.Errorf("invalid status given %s: must be %q, %q or %q", status, AccountStatusActive, AccountStatusPending, AccountStatusRejected)
+	}
+
+	accounts, total, err := s.store.GetAccountsCreatedBetween(r.Context(), from, to, status, limit, offset)
+	if err != nil {
+		return err
+	}
+	s.localizeAccounts(r, accounts...)
+	data, err := selectAccountFields(accounts, fields)
+	if err != nil {
+		return err
+	}
+	return writeList(w, r, ListResponse{
+		Data:   data,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handleGetAllTransactions is the ledger feed across every account, newest
+// first. created_from is inclusive and created_to exclusive, and it takes
+// the same metadata and category filters as an account's transaction
+// listing.
+func (s *ApiServer) handleGetAllTransactions(w http.ResponseWriter, r *http.Request) error {
+	from, err := getTimeParam(r, "created_from", time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := getTimeParam(r, "created_to", time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("created_from must be before created_to")
+	}
+
+	limit, offset, err := getPagination(r)
+	if err != nil {
+		return err
+	}
+
+	category := r.URL.Query().Get("category")
+	if err := validateCategory(category); err != nil {
+		return err
+	}
+
+	filter := TransactionFilter{From: from, To: to, Metadata: getMetadataFilter(r), Category: category}
+	entries, total, err := s.store.GetAllTransactions(r.Context(), filter, limit, offset)
+	if err != nil {
+		return err
+	}
+	s.localizeEntries(r, entries...)
+	return writeList(w, r, ListResponse{
+		Data:   entries,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handleVerifyLedger replays one account's ledger against its stored
+// balance. With repair=true a drifted balance is corrected and the repair
+// is audited under the calling admin.
+func (s *ApiServer) handleVerifyLedger(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	var repair *AuditEvent
+	switch v := r.URL.Query().Get("repair"); v {
+	case "", "false":
+	case "true":
+		admin := accountFromContext(r.Context())
+		repair = &AuditEvent{
+			ActorID:   &admin.ID,
+			Action:    AuditActionLedgerRepair,
+			Outcome:   AuditOutcomeSuccess,
+			IP:        clientIP(r),
+			CreatedAt: NewTimestamp(time.Now()),
+		}
+	default:
+		return fmt.Errorf("invalid repair given %s: must be true or false", v)
+	}
+
+	verification, err := s.store.VerifyLedger(r.Context(), int64(id), repair)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, verification)
+}
+
+// handleReconcile reports every account whose stored balance disagrees
+// with its ledger.
+func (s *ApiServer) handleReconcile(w http.ResponseWriter, r *http.Request) error {
+	mismatches, err := s.store.GetBalanceMismatches(r.Context())
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{
+		"checked_at": NewTimestamp(time.Now()),
+		"mismatches": mismatches,
+	})
+}
+
+// handleDBStats reports the database connection pools, for tuning the
+// DB_MAX_* settings.
+func (s *ApiServer) handleDBStats(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, s.store.PoolStats())
+}
+
+// handleRevokeTokens bumps the token epoch, logging out every client at
+// once and voiding every API key created so far. It is meant for incidents
+// such as a leaked JWT secret.
+func (s *ApiServer) handleRevokeTokens(w http.ResponseWriter, r *http.Request) error {
+	epoch, err := s.store.BumpTokenEpoch(r.Context())
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{"token_epoch": NewTimestamp(epoch)})
+}
+
+// handleDeleteAccounts closes a batch of accounts atomically, the way
+// DELETE /accounts/{id} closes one. A batch holding a funded account is
+// refused with 409 and the per-account results, and nothing is closed.
+func (s *ApiServer) handleDeleteAccounts(w http.ResponseWriter, r *http.Request) error {
+	req := &DeleteAccountsRequest{}
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if len(req.IDs) == 0 || len(req.IDs) > maxDeleteBatch {
+		return fmt.Errorf("ids must list between 1 and %d accounts", maxDeleteBatch)
+	}
+
+	results, err := s.store.AnonymizeAccounts(r.Context(), req.IDs)
+	if errors.Is(err, ErrAccountHasFunds) {
+		return &deleteBatchError{err: err, Results: results}
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// handleFreezeAccounts freezes every account matching the request at once,
+// for fraud response. Each frozen account gets its own audit event naming
+// the admin.
+func (s *ApiServer) handleFreezeAccounts(w http.ResponseWriter, r *http.Request) error {
+	req := &FreezeRequest{}
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if req.CreatedFrom == nil && req.CreatedTo == nil && req.NamePattern == "" {
+		return fmt.Errorf("give at least one of created_from, created_to or name_pattern")
+	}
+	filter := AccountFilter{
+		CreatedFrom: time.Time{},
+		CreatedTo:   time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC),
+		NamePattern: req.NamePattern,
+	}
+	if req.CreatedFrom != nil {
+		filter.CreatedFrom = req.CreatedFrom.UTC()
+	}
+	if req.CreatedTo != nil {
+		filter.CreatedTo = req.CreatedTo.UTC()
+	}
+	if !filter.CreatedFrom.Before(filter.CreatedTo) {
+		return fmt.Errorf("created_from must be before created_to")
+	}
+
+	admin := accountFromContext(r.Context())
+	event := &AuditEvent{
+		ActorID:   &admin.ID,
+		Action:    AuditActionAccountFreeze,
+		Outcome:   AuditOutcomeSuccess,
+		IP:        clientIP(r),
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	count, err := s.store.FreezeAccounts(r.Context(), filter, event, req.DryRun)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]any{
+		"frozen":  count,
+		"dry_run": req.DryRun,
+	})
+}
+
+func (s *ApiServer) handleApproveAccount(w http.ResponseWriter, r *http.Request) error {
+	return s.decideAccount(w, r, AccountStatusActive, AuditActionAccountApprove)
+}
+
+func (s *ApiServer) handleRejectAccount(w http.ResponseWriter, r *http.Request) error {
+	return s.decideAccount(w, r, AccountStatusRejected, AuditActionAccountReject)
+}
+
+// decideAccount moves a pending account to status and records which admin
+// made the call.
+func (s *ApiServer) decideAccount(w http.ResponseWriter, r *http.Request, status, action string) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	admin := accountFromContext(r.Context())
+	event := &AuditEvent{
+		ActorID:   &admin.ID,
+		Action:    action,
+		Outcome:   AuditOutcomeSuccess,
+		IP:        clientIP(r),
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	account, err := s.store.DecideAccount(r.Context(), id, status, event)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, account)
+}
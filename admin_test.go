@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestAdmin creates an admin account in store and returns a JWT for it.
+func newTestAdmin(t *testing.T, store Storage) string {
+	t.Helper()
+	admin, err := NewAccount(context.Background(), "Grace", "Hopper", "correct horse battery staple", uuid.NewString(), Pepper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.Role = RoleAdmin
+	if err := store.CreateAccount(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	return testToken(t, admin)
+}
+
+func TestHandleDeleteAccounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		problem     bool
+		withFunded  bool
+		wantStatus  int
+		wantResults []string
+	}{
+		{"all empty", false, false, http.StatusOK, []string{DeleteResultDeleted, DeleteResultDeleted, DeleteResultNotFound}},
+		{"one funded", false, true, http.StatusConflict, []string{DeleteResultRolledBack, DeleteResultHasFunds, DeleteResultNotFound}},
+		{"one funded as problem", true, true, http.StatusConflict, []string{DeleteResultRolledBack, DeleteResultHasFunds, DeleteResultNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			token := newTestAdmin(t, store)
+			var opening Money
+			if tt.withFunded {
+				opening = 500
+			}
+			empty := newTestAccount(t, store, 0)
+			second := newTestAccount(t, store, opening)
+			server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+
+			body := fmt.Sprintf(`{"ids":[%d,%d,999]}`, empty.ID, second.ID)
+			req := httptest.NewRequest(http.MethodPost, "/admin/accounts/delete", strings.NewReader(body))
+			req.Header.Set("x-jwt-token", token)
+			if tt.problem {
+				req.Header.Set("Accept", problemContentType)
+			}
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var resp struct {
+				Error   string          `json:"error"`
+				Type    string          `json:"type"`
+				Results []*DeleteResult `json:"results"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, result := range resp.Results {
+				got = append(got, result.Result)
+			}
+			if !reflect.DeepEqual(got, tt.wantResults) {
+				t.Errorf("results = %v, want %v", got, tt.wantResults)
+			}
+
+			switch {
+			case tt.problem:
+				if resp.Type != "urn:gobank:problem:account-has-funds" {
+					t.Errorf("type = %q", resp.Type)
+				}
+			case tt.withFunded:
+				if resp.Error != ErrAccountHasFunds.Error() {
+					t.Errorf("error = %q, want %q", resp.Error, ErrAccountHasFunds.Error())
+				}
+			}
+
+			_, err := store.GetAccountByID(context.Background(), int(empty.ID))
+			if closed := err != nil; closed == tt.withFunded {
+				t.Errorf("empty account closed = %v, want %v", closed, !tt.withFunded)
+			}
+		})
+	}
+}
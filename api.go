@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/mux"
@@ -16,54 +25,176 @@ import (
 type ApiServer struct {
 	listenAddr string
 	store      Storage
+	config     *Config
+	numbers    NumberGenerator
+	currency   Currency
+	// tokens is nil unless logins reuse recently issued tokens.
+	tokens *tokenCache
+	// disabled holds the features switched off by configuration.
+	disabled map[string]bool
+	// verifyLimiter caps recipient lookups per caller, so they cannot be
+	// used to enumerate account numbers.
+	verifyLimiter *rateLimiter
+	// geo enriches login audit events; see GeoLocator.
+	geo GeoLocator
+	// transferLimiter caps the transfers and sweeps each account has in
+	// flight. It is nil when they are not limited.
+	transferLimiter *inflightLimiter
 }
 
-func NewApiServer(listenAddr string, store Storage) *ApiServer {
-	return &ApiServer{
+func NewApiServer(listenAddr string, store Storage, config *Config) *ApiServer {
+	s := &ApiServer{
 		listenAddr: listenAddr,
 		store:      store,
+		config:     config,
+		numbers:    newNumberGenerator(config.AccountNumberFormat, config.AccountNumberPrefix, store),
+		currency:   currencies[config.Currency],
+		disabled:   map[string]bool{},
+		geo:        noGeoLocator{},
+
+		verifyLimiter: newRateLimiter(config.RecipientVerifyLimit, time.Minute),
+	}
+	for _, feature := range config.DisabledFeatures {
+		s.disabled[feature] = true
+	}
+	if config.TransferConcurrency > 0 {
+		s.transferLimiter = newInflightLimiter(config.TransferConcurrency)
+	}
+	if config.LoginTokenPolicy == LoginTokenPolicyReuse {
+		s.tokens = newTokenCache(config.LoginTokenReuseWindow)
+	}
+	return s
+}
+
+// Run serves the API until ctx is cancelled, then stops accepting new
+// connections and gives in-flight requests up to the configured shutdown
+// timeout to finish before closing whatever is still open.
+func (s *ApiServer) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    s.listenAddr,
+		Handler: s.Handler(),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Println("JSON API Server running on port", s.listenAddr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down, draining requests for up to", s.config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("shutdown timeout exceeded, closing remaining connections")
+		return srv.Close()
 	}
+	return nil
 }
 
-func (s *ApiServer) Run() {
+func (s *ApiServer) Handler() http.Handler {
 	router := mux.NewRouter()
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
 
 	router.HandleFunc("/login", makeHandleFunc(s.handleLogin)).Methods("POST")
-	router.HandleFunc("/accounts", makeHandleFunc(s.handleAccount)).Methods("GET", "POST")
+	router.HandleFunc("/config", makeHandleFunc(s.handleGetConfig)).Methods("GET")
+	router.HandleFunc("/auth/verify", makeHandleFunc(s.handleVerifyToken)).Methods("POST")
+	router.HandleFunc("/auth/methods", makeHandleFunc(s.handleGetAuthMethods)).Methods("GET")
+	router.HandleFunc("/accounts", withAuth(makeHandleFunc(s.handleGetAccounts), s.store)).Methods("GET")
+	router.HandleFunc("/accounts", s.withFeature(FeatureSignup, makeHandleFunc(s.handleCreateAccount))).Methods("POST")
+	router.HandleFunc("/accounts/verify", withAuth(makeHandleFunc(s.handleVerifyRecipient), s.store)).Methods("GET")
 	router.HandleFunc("/accounts/{id}", withJWTAuth(makeHandleFunc(s.handleAccountById), s.store)).Methods("GET", "DELETE")
-	router.HandleFunc("/transfer", makeHandleFunc(s.handleTrasfer)).Methods("POST")
+	router.HandleFunc("/accounts/{id}/restore", withClosedOwnerOrAdmin(makeHandleFunc(s.handleRestoreAccount), s.store)).Methods("POST")
+	router.HandleFunc("/accounts/{id}/password", withJWTAuth(makeHandleFunc(s.handleChangePassword), s.store)).Methods("PUT")
+	router.HandleFunc("/accounts/{id}/transactions", withJWTAuth(makeHandleFunc(s.handleGetTransactions), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/summary", withJWTAuth(makeHandleFunc(s.handleGetSummary), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/transactions/categories", withJWTAuth(makeHandleFunc(s.handleGetCategoryTotals), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/beneficiaries", s.withFeature(FeatureBeneficiaries, withJWTAuth(makeHandleFunc(s.handleGetBeneficiaries), s.store))).Methods("GET")
+	router.HandleFunc("/accounts/{id}/beneficiaries", s.withFeature(FeatureBeneficiaries, withJWTAuth(makeHandleFunc(s.handleCreateBeneficiary), s.store))).Methods("POST")
+	router.HandleFunc("/accounts/{id}/beneficiaries/{beneficiaryID}", s.withFeature(FeatureBeneficiaries, withJWTAuth(makeHandleFunc(s.handleDeleteBeneficiary), s.store))).Methods("DELETE")
+	router.HandleFunc("/accounts/{id}/statement", withOwnerOrAdmin(makeHandleFunc(s.handleGetStatement), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/snapshots", withOwnerOrAdmin(makeHandleFunc(s.handleGetBalanceSnapshots), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/whitelist", withAdmin(makeHandleFunc(s.handleGetWhitelist), s.store)).Methods("GET")
+	router.HandleFunc("/accounts/{id}/whitelist", withAdmin(makeHandleFunc(s.handleSetWhitelist), s.store)).Methods("PUT")
+	router.HandleFunc("/accounts/{id}/whitelist/entries", withAdmin(makeHandleFunc(s.handleAddWhitelistEntry), s.store)).Methods("POST")
+	router.HandleFunc("/accounts/{id}/whitelist/entries/{number}", withAdmin(makeHandleFunc(s.handleDeleteWhitelistEntry), s.store)).Methods("DELETE")
+	router.HandleFunc("/accounts/{id}/balance", s.withFeature(FeatureBalanceAsOf, withJWTAuth(makeHandleFunc(s.handleGetBalanceAsOf), s.store))).Methods("GET")
+	router.HandleFunc("/accounts/{id}/sweep", s.withFeature(FeatureSweep, withOwnerOrAdmin(makeHandleFunc(s.handleSweep), s.store))).Methods("POST")
+	router.HandleFunc("/transfer", s.withFeature(FeatureTransfers, withAuth(makeHandleFunc(s.handleTrasfer), s.store))).Methods("POST")
+	router.HandleFunc("/accounts/{id}/approve", withAdmin(makeHandleFunc(s.handleApproveAccount), s.store)).Methods("POST")
+	router.HandleFunc("/accounts/{id}/reject", withAdmin(makeHandleFunc(s.handleRejectAccount), s.store)).Methods("POST")
+	router.HandleFunc("/admin/accounts", withAdmin(makeHandleFunc(s.handleGetAccountsCreated), s.store)).Methods("GET")
+	router.HandleFunc("/admin/transactions", withAdmin(makeHandleFunc(s.handleGetAllTransactions), s.store)).Methods("GET")
+	router.HandleFunc("/admin/accounts/delete", withAdmin(makeHandleFunc(s.handleDeleteAccounts), s.store)).Methods("POST")
+	router.HandleFunc("/admin/freeze", withAdmin(makeHandleFunc(s.handleFreezeAccounts), s.store)).Methods("POST")
+	router.HandleFunc("/admin/tokens/revoke", withAdmin(makeHandleFunc(s.handleRevokeTokens), s.store)).Methods("POST")
+	router.HandleFunc("/admin/db-stats", withAdmin(makeHandleFunc(s.handleDBStats), s.store)).Methods("GET")
+	router.HandleFunc("/admin/accounts/{id}/verify-ledger", withAdmin(makeHandleFunc(s.handleVerifyLedger), s.store)).Methods("POST")
+	router.HandleFunc("/admin/reconcile", withAdmin(makeHandleFunc(s.handleReconcile), s.store)).Methods("GET")
+	router.HandleFunc("/me/api-keys", withAuth(makeHandleFunc(s.handleGetAPIKeys), s.store)).Methods("GET")
+	router.HandleFunc("/me/api-keys", withAuth(makeHandleFunc(s.handleCreateAPIKey), s.store)).Methods("POST")
+	router.HandleFunc("/me/api-keys/{keyID}", withAuth(makeHandleFunc(s.handleRevokeAPIKey), s.store)).Methods("DELETE")
+	router.HandleFunc("/me/logins", withAuth(makeHandleFunc(s.handleGetLogins), s.store)).Methods("GET")
 
-	log.Println("JSON API Server running on port", s.listenAddr)
-	http.ListenAndServe(s.listenAddr, router)
+	var handler http.Handler = withHead(router)
+	handler = withConcurrencyLimit(handler, s.config.MaxConcurrentRequests)
+	handler = withTimeout(handler, s.config.RequestTimeout)
+	handler = withAllowedHosts(handler, s.config.AllowedHosts)
+	handler = withErrorFormat(handler, s.config)
+	handler = withResponseShape(handler, s.config)
+	return withCORS(handler, s.config)
 }
 
-func (s *ApiServer) handleAccount(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == "GET" {
-		return s.handleGetAccounts(w, r)
+func (s *ApiServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
+	var req LoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
 	}
-	if r.Method == "POST" {
-		return s.handleCreateAccount(w, r)
+	if err := req.Validate(); err != nil {
+		return err
 	}
 
-	return fmt.Errorf("method not allowed %s", r.Method)
-}
-
-func (s *ApiServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	acc, err := s.store.GetAccountByNumber(r.Context(), req.Number)
+	if err != nil {
 		return err
 	}
 
-	acc, err := s.store.GetAccountByNumber(req.Number)
+	locked, err := s.isLockedOut(r.Context(), acc)
 	if err != nil {
 		return err
 	}
+	if locked {
+		s.recordLogin(r, acc, AuditOutcomeLocked)
+		return newStatusError(http.StatusLocked, "account temporarily locked after too many failed logins")
+	}
 
-	if !acc.ValidatePassword(req.Password) {
+	ok, rehash, err := acc.ValidatePassword(r.Context(), req.Password, s.config.Pepper)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.recordLogin(r, acc, AuditOutcomeFailure)
 		return fmt.Errorf("not authenticated")
 	}
+	s.recordLogin(r, acc, AuditOutcomeSuccess)
 
-	token, err := createJWT(acc)
+	// Closed accounts still get a token, but only the restore route takes
+	// it.
+	if err := checkAccountActive(acc); err != nil && acc.Status != AccountStatusClosed {
+		return err
+	}
+
+	if rehash {
+		s.upgradePasswordHash(r.Context(), acc, req.Password)
+	}
+
+	token, err := s.issueToken(r.Context(), acc)
 	if err != nil {
 		return err
 	}
@@ -76,12 +207,211 @@ func (s *ApiServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
 	return WriteJSON(w, http.StatusOK, resp)
 }
 
+// isLockedOut reports whether the account has had too many failed logins
+// within the lockout window since its last successful one.
+func (s *ApiServer) isLockedOut(ctx context.Context, acc *Account) (bool, error) {
+	if s.config.LoginMaxFailures <= 0 {
+		return false, nil
+	}
+
+	since := time.Now().UTC().Add(-s.config.LoginLockoutWindow)
+	failures, err := s.store.CountLoginFailuresSince(ctx, acc.ID, since)
+	if err != nil {
+		return false, err
+	}
+	return failures >= s.config.LoginMaxFailures, nil
+}
+
+// serverConfigMaxAge is how long clients and proxies may cache /config.
+// It only changes on a restart with new settings.
+const serverConfigMaxAge = 5 * time.Minute
+
+// handleGetConfig is public: it reports limits any client runs into anyway.
+func (s *ApiServer) handleGetConfig(w http.ResponseWriter, r *http.Request) error {
+	disabled := []string{}
+	for _, feature := range featureNames() {
+		if s.disabled[feature] {
+			disabled = append(disabled, feature)
+		}
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(serverConfigMaxAge.Seconds())))
+	return WriteJSON(w, http.StatusOK, ServerConfigResponse{
+		Currencies:         []Currency{s.currency},
+		MinTransferAmount:  s.config.MinTransferAmount,
+		AccountTypes:       []string{AccountTypeChecking, AccountTypeSavings},
+		PasswordPolicy:     s.config.PasswordPolicy,
+		TwoFactorAvailable: false,
+		DisabledFeatures:   disabled,
+	})
+}
+
+// handleGetAuthMethods is public, like /config, so clients can pick a login
+// flow before they have any credentials. Two-factor authentication is not
+// implemented yet and is always reported as disabled.
+func (s *ApiServer) handleGetAuthMethods(w http.ResponseWriter, r *http.Request) error {
+	password := AuthMethod{
+		Name:            AuthMethodPassword,
+		Enabled:         true,
+		Endpoint:        "/login",
+		Header:          "x-jwt-token",
+		TokenTTLSeconds: int(tokenTTL.Seconds()),
+		PasswordPolicy:  &s.config.PasswordPolicy,
+	}
+	if s.config.LoginMaxFailures > 0 {
+		password.MaxFailedLogins = s.config.LoginMaxFailures
+		password.LockoutSeconds = int(s.config.LoginLockoutWindow.Seconds())
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(serverConfigMaxAge.Seconds())))
+	return WriteJSON(w, http.StatusOK, AuthMethodsResponse{
+		Methods: []AuthMethod{
+			password,
+			{Name: AuthMethodMFA, Enabled: false},
+			{
+				Name:     AuthMethodAPIKey,
+				Enabled:  true,
+				Endpoint: "/me/api-keys",
+				Header:   apiKeyHeader,
+				Scopes:   []string{ScopeRead, ScopeWrite},
+			},
+		},
+	})
+}
+
+// handleVerifyToken tells a client whether a token would be accepted, and
+// until when, without it having to call a protected endpoint. A bad token
+// is a normal answer here, not an error.
+func (s *ApiServer) handleVerifyToken(w http.ResponseWriter, r *http.Request) error {
+	var req VerifyTokenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+
+	resp := VerifyTokenResponse{}
+	token, err := validateJWT(req.Token)
+	if err != nil || !token.Valid {
+		return WriteJSON(w, http.StatusOK, resp)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	epoch, err := s.store.GetTokenEpoch(r.Context())
+	if err != nil {
+		return err
+	}
+	if issuedBefore(claims, epoch) {
+		return WriteJSON(w, http.StatusOK, resp)
+	}
+
+	resp.Valid = true
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt := NewTimestamp(time.Unix(int64(exp), 0))
+		resp.ExpiresAt = &expiresAt
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// upgradePasswordHash replaces a hash made under an old pepper with one made
+// under the current pepper. It is best effort: the old hash keeps working
+// until the next login if this fails.
+func (s *ApiServer) upgradePasswordHash(ctx context.Context, acc *Account, pw string) {
+	encpw, err := hashPassword(ctx, pw, s.config.Pepper.Current)
+	if err != nil {
+		log.Println("failed to rehash password:", err)
+		return
+	}
+	if err := s.store.UpdatePassword(ctx, acc.ID, encpw); err != nil {
+		log.Println("failed to store rehashed password:", err)
+		return
+	}
+	acc.EncryptedPassword = encpw
+}
+
+// SetGeoLocator makes login audit events carry where the client IP was
+// located. It must be called before the server starts.
+func (s *ApiServer) SetGeoLocator(geo GeoLocator) {
+	s.geo = geo
+}
+
+// recordLogin writes a login attempt to the audit log. Failing to audit, or
+// to locate the client, is logged but does not fail the login itself.
+func (s *ApiServer) recordLogin(r *http.Request, acc *Account, outcome string) {
+	event := &AuditEvent{
+		AccountID: acc.ID,
+		Action:    AuditActionLogin,
+		Outcome:   outcome,
+		IP:        clientIP(r),
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	geo, err := s.geo.Locate(r.Context(), event.IP)
+	if err != nil {
+		log.Println("failed to locate login IP:", err)
+	}
+	event.Geo = geo
+	if err := s.store.CreateAuditEvent(r.Context(), event); err != nil {
+		log.Println("failed to record login audit event:", err)
+	}
+}
+
+func (s *ApiServer) handleGetLogins(w http.ResponseWriter, r *http.Request) error {
+	account := accountFromContext(r.Context())
+
+	limit, offset, err := getPagination(r)
+	if err != nil {
+		return err
+	}
+
+	events, total, err := s.store.GetLoginHistory(r.Context(), account.ID, limit, offset)
+	if err != nil {
+		return err
+	}
+	return writeList(w, r, ListResponse{
+		Data:   events,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handleGetAccounts lists every account in the bank, so it is reserved for
+// admins; other callers only ever see their own data.
 func (s *ApiServer) handleGetAccounts(w http.ResponseWriter, r *http.Request) error {
-	accounts, err := s.store.GetAccounts()
+	if roleFromContext(r.Context()) != RoleAdmin {
+		return errPermissionDenied
+	}
+
+	limit, offset, err := getPagination(r)
 	if err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, accounts)
+	fields, err := getFields(r, accountFields)
+	if err != nil {
+		return err
+	}
+
+	accounts, total, err := s.store.GetAccounts(r.Context(), limit, offset)
+	var partial *PartialResultsError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+	s.localizeAccounts(r, accounts...)
+	data, err := selectAccountFields(accounts, fields)
+	if err != nil {
+		return err
+	}
+	page := ListResponse{
+		Data:   data,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if partial != nil {
+		page.Skipped = partial.Skipped
+	}
+	return writeList(w, r, page)
 }
 
 func (s *ApiServer) handleAccountById(w http.ResponseWriter, r *http.Request) error {
@@ -90,71 +420,469 @@ func (s *ApiServer) handleAccountById(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 	if r.Method == "GET" {
-		account, err := s.store.GetAccountByID(id)
+		fields, err := getFields(r, accountFields)
 		if err != nil {
 			return err
 		}
+		account, err := s.store.GetAccountByID(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		s.localizeAccounts(r, account)
 
-		return WriteJSON(w, http.StatusOK, account)
+		resp, err := selectFields(account, fields)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, resp)
 	}
 
 	if r.Method == "DELETE" {
-		id, err = s.store.DeleteAccount(id)
+		var deleted int
+		if s.config.AccountDeletionGrace > 0 {
+			deleted, err = s.store.CloseAccount(r.Context(), id, time.Now().UTC().Add(s.config.AccountDeletionGrace))
+		} else {
+			deleted, err = s.store.AnonymizeAccount(r.Context(), id)
+		}
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		if id == 0 {
-			err = fmt.Errorf("account %d not found", id)
-			return WriteJSON(w, http.StatusNotFound, ApiError{Error: err.Error()})
+		if deleted == 0 {
+			return fmt.Errorf("account %d %w", id, ErrAccountNotFound)
 		}
-		return WriteJSON(w, http.StatusNoContent, map[string]int{"deleted": id})
+		return WriteJSON(w, http.StatusNoContent, map[string]int{"deleted": deleted})
 	}
 
-	return fmt.Errorf("method not allowed %s", r.Method)
+	return methodNotAllowed(w, r, "GET", "DELETE")
 }
 
 func (s *ApiServer) handleCreateAccount(w http.ResponseWriter, r *http.Request) error {
 	req := &CreateAccountRequest{}
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	if err := decodeJSON(r, req); err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
-	account, err := NewAccount(req.FirstName, req.LastName, req.Password)
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	if err := s.config.PasswordPolicy.Check(req.Password); err != nil {
+		return err
+	}
+
+	number, err := s.newAccountNumber(r.Context())
 	if err != nil {
 		return err
 	}
 
-	if err := s.store.CreateAccount(account); err != nil {
+	account, err := NewAccount(r.Context(), req.FirstName, req.LastName, req.Password, number, s.config.Pepper)
+	if err != nil {
 		return err
 	}
+	account.Balance = req.InitialBalance
+	if s.config.AccountApprovalRequired {
+		account.Status = AccountStatusPending
+	}
+	if req.AccountType != "" {
+		account.Type = req.AccountType
+	}
+
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		if err := s.store.CreateAccount(r.Context(), account); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusCreated, account)
+	}
 
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("%s must be at most %d bytes", idempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+	since := time.Now().UTC().Add(-s.config.IdempotencyKeyTTL)
+	existing, err := s.store.CreateAccountOnce(r.Context(), account, key, since)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		w.Header().Set("Idempotent-Replayed", "true")
+		return WriteJSON(w, http.StatusCreated, existing)
+	}
 	return WriteJSON(w, http.StatusCreated, account)
 }
 
+// A client retrying a signup after a timeout sends the same
+// Idempotency-Key, and gets the account the first attempt made rather
+// than a second one.
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+func (s *ApiServer) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
+	req := &ChangePasswordRequest{}
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if req.NewPassword == "" {
+		return fmt.Errorf("new_password is required")
+	}
+	if err := s.config.PasswordPolicy.Check(req.NewPassword); err != nil {
+		return err
+	}
+
+	account := accountFromContext(r.Context())
+	ok, _, err := account.ValidatePassword(r.Context(), req.CurrentPassword, s.config.Pepper)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errPermissionDenied
+	}
+
+	if err := s.checkPasswordReuse(r.Context(), account, req.NewPassword); err != nil {
+		return err
+	}
+
+	encpw, err := hashPassword(r.Context(), req.NewPassword, s.config.Pepper.Current)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ChangePassword(r.Context(), account.ID, encpw, s.config.PasswordHistory-1); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]string{"status": "password changed"})
+}
+
+// checkPasswordReuse rejects pw when it matches the current password or
+// one of the previous ones kept in the password history.
+func (s *ApiServer) checkPasswordReuse(ctx context.Context, account *Account, pw string) error {
+	if s.config.PasswordHistory <= 0 {
+		return nil
+	}
+
+	hashes, err := s.store.GetPasswordHistory(ctx, account.ID, s.config.PasswordHistory-1)
+	if err != nil {
+		return err
+	}
+	hashes = append([]string{account.EncryptedPassword}, hashes...)
+
+	for _, hash := range hashes {
+		previous := &Account{EncryptedPassword: hash}
+		ok, _, err := previous.ValidatePassword(ctx, pw, s.config.Pepper)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("new password must differ from your last %d passwords", s.config.PasswordHistory)
+		}
+	}
+	return nil
+}
+
+// newAccountNumber generates an account number that is not in use yet,
+// retrying a bounded number of times on collision.
+func (s *ApiServer) newAccountNumber(ctx context.Context) (string, error) {
+	for attempt := 0; attempt < s.config.AccountNumberAttempts; attempt++ {
+		number, err := s.numbers.Generate(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err := s.numbers.Validate(number); err != nil {
+			return "", err
+		}
+
+		exists, err := s.store.AccountExists(ctx, number)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return number, nil
+		}
+	}
+	return "", statusErrorf(http.StatusInternalServerError,
+		"could not generate a unique account number in %d attempts: the %s number space may be running out",
+		s.config.AccountNumberAttempts, s.config.AccountNumberFormat)
+}
+
 func (s *ApiServer) handleTrasfer(w http.ResponseWriter, r *http.Request) error {
 	transferRequest := &TransferRequest{}
-	if err := json.NewDecoder(r.Body).Decode(transferRequest); err != nil {
+	if err := decodeJSON(r, transferRequest); err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
-	id, err := s.store.Transfer(transferRequest.ToAccount, transferRequest.Amount)
+	if err := transferRequest.Validate(); err != nil {
+		return err
+	}
+	if transferRequest.Amount < s.config.MinTransferAmount {
+		return fmt.Errorf("amount must be at least %s %s", s.currency.Format(s.config.MinTransferAmount, moneyLocales["en"]), s.currency.Code)
+	}
+
+	from := accountFromContext(r.Context())
+	if transferRequest.BeneficiaryID != 0 {
+		beneficiary, err := s.store.GetBeneficiary(r.Context(), from.ID, transferRequest.BeneficiaryID)
+		if err != nil {
+			return err
+		}
+		transferRequest.ToAccount = beneficiary.Number
+	}
+	if err := checkDestinationNumber(s.config.AccountNumberFormat, s.config.AccountNumberPrefix, transferRequest.ToAccount); err != nil {
+		return err
+	}
+
+	if transferRequest.ToAccount == from.Number {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+
+	release, err := s.startTransfer(from.ID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	result, err := s.store.Transfer(r.Context(), from.Number, transferRequest.ToAccount, transferRequest.Amount, transferRequest.Category, transferRequest.Metadata)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("account %s %w", transferRequest.ToAccount, ErrAccountNotFound)
+	}
+	return WriteJSON(w, http.StatusOK, result)
+}
+
+// startTransfer claims one of the account's in-flight transfer slots,
+// failing with 429 when all are taken. The returned func frees it.
+func (s *ApiServer) startTransfer(accountID int64) (func(), error) {
+	if s.transferLimiter == nil {
+		return func() {}, nil
 	}
-	if id == 0 {
-		err = fmt.Errorf("account not %s not found", transferRequest.ToAccount)
-		return WriteJSON(w, http.StatusNotFound, ApiError{Error: err.Error()})
+	release, ok := s.transferLimiter.acquire(accountID)
+	if !ok {
+		return nil, newStatusError(http.StatusTooManyRequests, "too many transfers in progress for this account, try again once they finish")
+	}
+	return release, nil
+}
+
+// handleSweep empties the account into the destination account, for
+// closing an account out without knowing its exact balance.
+func (s *ApiServer) handleSweep(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	sweepRequest := &SweepRequest{}
+	if err := decodeJSON(r, sweepRequest); err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if err := checkDestinationNumber(s.config.AccountNumberFormat, s.config.AccountNumberPrefix, sweepRequest.ToAccount); err != nil {
+		return err
+	}
+
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.startTransfer(account.ID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	amount, err := s.store.Sweep(r.Context(), account.ID, sweepRequest.ToAccount)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{
+		"transfered": amount,
+		"from":       account.Number,
+		"to":         sweepRequest.ToAccount,
+	})
+}
+
+// handleGetBalanceAsOf reports the balance from the ledger at as_of. A
+// date such as 2024-01-01 means the close of that day in UTC; a full
+// RFC3339 timestamp means that exact moment.
+func (s *ApiServer) handleGetBalanceAsOf(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return fmt.Errorf("as_of is required")
+	}
+	before, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		day, dayErr := time.Parse("2006-01-02", v)
+		if dayErr != nil {
+			return fmt.Errorf("invalid as_of given %s: must be a date or an RFC3339 timestamp", v)
+		}
+		before = day.AddDate(0, 0, 1)
+	}
+
+	balance, err := s.store.GetBalanceAsOf(r.Context(), int64(id), before.UTC())
+	if err != nil {
+		return err
 	}
 	return WriteJSON(w, http.StatusOK, map[string]any{
-		"transfered": transferRequest.Amount,
-		"to":         transferRequest.ToAccount,
+		"as_of":   v,
+		"balance": balance,
 	})
 }
 
+// handleVerifyRecipient lets a sender confirm who a number belongs to
+// before transferring, showing only the first name and last initial.
+// Accounts that cannot receive transfers are reported as not existing.
+func (s *ApiServer) handleVerifyRecipient(w http.ResponseWriter, r *http.Request) error {
+	caller := accountFromContext(r.Context())
+	if ok, retry := s.verifyLimiter.allow(caller.ID, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		return newStatusError(http.StatusTooManyRequests, "too many recipient lookups, try again later")
+	}
+
+	number := r.URL.Query().Get("number")
+	if number == "" {
+		return fmt.Errorf("number is required")
+	}
+	if err := checkDestinationNumber(s.config.AccountNumberFormat, s.config.AccountNumberPrefix, number); err != nil {
+		return err
+	}
+
+	account, err := s.store.GetAccountByNumber(r.Context(), number)
+	if errors.Is(err, ErrAccountNotFound) || (err == nil && account.Status != AccountStatusActive) {
+		return WriteJSON(w, http.StatusOK, VerifyRecipientResponse{Exists: false})
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, VerifyRecipientResponse{
+		Exists:      true,
+		DisplayName: displayName(account),
+	})
+}
+
+// displayName is the first name and last initial, such as "John S.".
+func displayName(acc *Account) string {
+	name := strings.TrimSpace(acc.FirstName)
+	if r, _ := utf8.DecodeRuneInString(strings.TrimSpace(acc.LastName)); r != utf8.RuneError {
+		name += " " + string(unicode.ToUpper(r)) + "."
+	}
+	return strings.TrimSpace(name)
+}
+
+func (s *ApiServer) handleGetTransactions(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	limit, offset, err := getPagination(r)
+	if err != nil {
+		return err
+	}
+
+	category := r.URL.Query().Get("category")
+	if err := validateCategory(category); err != nil {
+		return err
+	}
+
+	filter := TransactionFilter{
+		To:       time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC),
+		Metadata: getMetadataFilter(r),
+		Category: category,
+	}
+	entries, total, err := s.store.GetTransactions(r.Context(), int64(id), filter, limit, offset)
+	if err != nil {
+		return err
+	}
+	s.localizeEntries(r, entries...)
+	return writeList(w, r, ListResponse{
+		Data:   entries,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handleGetCategoryTotals sums the account's transactions per category,
+// over the account's whole history unless from or to narrow it.
+func (s *ApiServer) handleGetCategoryTotals(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	from, err := getTimeParam(r, "from", time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := getTimeParam(r, "to", time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	totals, err := s.store.GetCategoryTotals(r.Context(), int64(id), from, to)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{"categories": totals})
+}
+
+// handleGetSummary reports the account's inflows, outflows and net for the
+// current period, a month unless period says otherwise, up to now.
+func (s *ApiServer) handleGetSummary(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = SummaryPeriodMonth
+	}
+	now := time.Now().UTC()
+	from, err := periodStart(period, now)
+	if err != nil {
+		return err
+	}
+
+	inflows, outflows, err := s.store.GetFlowTotals(r.Context(), int64(id), from, now)
+	if err != nil {
+		return err
+	}
+	net, err := inflows.Sub(outflows)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, &FlowSummary{
+		Period:   period,
+		From:     NewTimestamp(from),
+		To:       NewTimestamp(now),
+		Inflows:  inflows,
+		Outflows: outflows,
+		Net:      net,
+	})
+}
+
+// ApiError is the default error body. Field, Offset and Expected point at
+// the problem in a request body that failed to decode.
 type ApiError struct {
-	Error string `json:"error"`
+	Error    string `json:"error"`
+	Field    string `json:"field,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	// Results lists the outcome per account of a refused batch delete.
+	Results []*DeleteResult `json:"results,omitempty"`
 }
 
 type apiFunc func(http.ResponseWriter, *http.Request) error
@@ -163,82 +891,389 @@ func makeHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
 			// handle errors in handle funcs
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			WriteError(w, r, err)
 		}
 	}
 }
 
+// decodeJSON reads the request body into v, turning the decoder's errors
+// into messages that say what is wrong with the body and where. A missing,
+// zero-length or whitespace-only body is errBodyRequired.
+func decodeJSON(r *http.Request, v any) error {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return errBodyRequired
+	}
+
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(v)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		if dec.More() {
+			return fmt.Errorf("request body must contain a single JSON value")
+		}
+		return nil
+	case errors.Is(err, io.EOF):
+		return errBodyRequired
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("request body is truncated JSON")
+	case errors.As(err, &syntaxErr):
+		return &decodeError{
+			msg:    fmt.Sprintf("malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr),
+			Offset: syntaxErr.Offset,
+		}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &decodeError{
+				msg:      fmt.Sprintf("request body must be a JSON object, got %s", typeErr.Value),
+				Offset:   typeErr.Offset,
+				Expected: "object",
+			}
+		}
+		return &decodeError{
+			msg:      fmt.Sprintf("field %q must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value),
+			Field:    typeErr.Field,
+			Offset:   typeErr.Offset,
+			Expected: typeErr.Type.String(),
+		}
+	default:
+		return err
+	}
+}
+
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
-	w.Header().Add("Content-Type", "application/json")
+	return writeEncoded(w, status, "application/json", v)
+}
+
+// maxPooledBuffer keeps the odd huge response from pinning its buffer in
+// the pool for good.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// writeEncoded is the one place responses are encoded. The body goes into
+// a pooled buffer first, so an encoding failure turns into a clean 500 and
+// not a half written body under the intended status. HTML escaping is off:
+// this is an API, and "<" in a name should come back as "<". Having the
+// whole body also gives Content-Length, and an ETag for successful
+// responses, which HEAD requests report without the body.
+func writeEncoded(w http.ResponseWriter, status int, contentType string, v any) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("failed to encode response: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, werr := io.WriteString(w, `{"error":"internal server error"}`+"\n")
+		return werr
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if status == http.StatusOK {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes())))
+	}
 	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
 
-	return json.NewEncoder(w).Encode(v)
+// writeList writes a page of a list endpoint, adding Link headers to the
+// neighbouring pages so clients can follow them without doing the offset
+// arithmetic themselves.
+func writeList(w http.ResponseWriter, r *http.Request, page ListResponse) error {
+	var links []string
+	if page.Offset+page.Limit < page.Total {
+		links = append(links, pageLink(r, page.Limit, page.Offset+page.Limit, "next"))
+	}
+	if page.Offset > 0 {
+		prev := page.Offset - page.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, pageLink(r, page.Limit, prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	if page.Skipped > 0 {
+		w.Header().Set("X-Partial-Results", strconv.Itoa(page.Skipped))
+	}
+	if responseShape(r) == ResponseShapeLegacy {
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		return WriteJSON(w, http.StatusOK, page.Data)
+	}
+	return WriteJSON(w, http.StatusOK, page)
 }
 
-func withJWTAuth(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+// pageLink is a Link header value pointing at the same request with limit
+// and offset replaced, keeping any filters.
+func pageLink(r *http.Request, limit, offset int, rel string) string {
+	u := *r.URL
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", u.RequestURI(), rel)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) error {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	return WriteError(w, r, statusErrorf(http.StatusMethodNotAllowed, "method not allowed %s", r.Method))
+}
+
+// methodNotAllowedHandler answers requests for a known path with an
+// unsupported verb, listing the verbs the path does accept in Allow.
+func methodNotAllowedHandler(router *mux.Router) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("calling JWT AUTH Middleware")
+		methodNotAllowed(w, r, allowedMethods(router, r)...)
+	}
+}
+
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	allowed := []string{}
+	seen := map[string]bool{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if seen[method] {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if route.Match(probe, &match) {
+				seen[method] = true
+				allowed = append(allowed, method)
+				if method == http.MethodGet && !seen[http.MethodHead] {
+					seen[http.MethodHead] = true
+					allowed = append(allowed, http.MethodHead)
+				}
+			}
+		}
+		return nil
+	})
+	return allowed
+}
+
+type contextKey string
+
+const (
+	accountContextKey contextKey = "account"
+	roleContextKey    contextKey = "role"
+	apiKeyContextKey  contextKey = "apiKey"
+)
+
+// withAuth validates the JWT and stores the caller's account and role claim
+// on the request context, where handlers can read them with
+// accountFromContext and roleFromContext.
+func withAuth(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+	return authenticate(handlerFunc, store, false)
+}
+
+// authenticate is withAuth, also letting closed accounts through when
+// allowClosed is set.
+func authenticate(handlerFunc http.HandlerFunc, store Storage, allowClosed bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(apiKeyHeader); key != "" && r.Header.Get("x-jwt-token") == "" {
+			withAPIKey(handlerFunc, store, key)(w, r)
+			return
+		}
+
 		tokenString := r.Header.Get("x-jwt-token")
 
 		token, err := validateJWT(tokenString)
 		if err != nil {
-			WriteJSON(w, http.StatusForbidden, ApiError{Error: "invalid token"})
+			WriteError(w, r, errInvalidToken)
 			return
 		}
 
 		if !token.Valid {
-			WriteJSON(w, http.StatusForbidden, ApiError{Error: "invalid token"})
+			WriteError(w, r, errInvalidToken)
 			return
 		}
 
-		userID, err := getID(r)
+		claims := token.Claims.(jwt.MapClaims)
+		number, ok := claims["accountNumber"].(string)
+		if !ok {
+			permissionDenied(w, r)
+			return
+		}
+
+		epoch, err := store.GetTokenEpoch(r.Context())
 		if err != nil {
-			permissionDenied(w)
+			WriteError(w, r, err)
+			return
+		}
+		if issuedBefore(claims, epoch) {
+			WriteError(w, r, errInvalidToken)
 			return
 		}
 
-		account, err := store.GetAccountByID(userID)
+		account, err := store.GetAccountByNumber(r.Context(), number)
+		if errors.Is(err, ErrCircuitOpen) {
+			WriteError(w, r, err)
+			return
+		}
 		if err != nil {
-			permissionDenied(w)
+			permissionDenied(w, r)
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		if account.Number != claims["accountNumber"] {
-			permissionDenied(w)
+		if err := checkAccountActive(account); err != nil && !(allowClosed && account.Status == AccountStatusClosed) {
+			WriteError(w, r, err)
+			return
+		}
+
+		role, _ := claims["role"].(string)
+
+		ctx := context.WithValue(r.Context(), accountContextKey, account)
+		ctx = context.WithValue(ctx, roleContextKey, role)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+// withJWTAuth authenticates the caller and only lets them through when the
+// {id} in the route is their own account.
+func withJWTAuth(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+	return withAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getID(r)
+		if err != nil {
+			permissionDenied(w, r)
 			return
 		}
 
+		if accountFromContext(r.Context()).ID != int64(userID) {
+			permissionDenied(w, r)
+			return
+		}
+
+		handlerFunc(w, r)
+	}, store)
+}
+
+// withOwnerOrAdmin is withJWTAuth that also lets admins act on any account.
+func withOwnerOrAdmin(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+	return withAuth(ownerOrAdmin(handlerFunc), store)
+}
+
+// withClosedOwnerOrAdmin is withOwnerOrAdmin for the one route a closed
+// account may still use: restoring itself.
+func withClosedOwnerOrAdmin(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+	return authenticate(ownerOrAdmin(handlerFunc), store, true)
+}
+
+func ownerOrAdmin(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if roleFromContext(r.Context()) != RoleAdmin {
+			userID, err := getID(r)
+			if err != nil || accountFromContext(r.Context()).ID != int64(userID) {
+				permissionDenied(w, r)
+				return
+			}
+		}
+
 		handlerFunc(w, r)
 	}
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	WriteJSON(w, http.StatusForbidden, ApiError{Error: "permission denied"})
+// withAdmin authenticates the caller and only lets admins through.
+func withAdmin(handlerFunc http.HandlerFunc, store Storage) http.HandlerFunc {
+	return withAuth(func(w http.ResponseWriter, r *http.Request) {
+		if roleFromContext(r.Context()) != RoleAdmin {
+			permissionDenied(w, r)
+			return
+		}
+
+		handlerFunc(w, r)
+	}, store)
 }
 
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET")
+func accountFromContext(ctx context.Context) *Account {
+	account, _ := ctx.Value(accountContextKey).(*Account)
+	return account
+}
+
+func roleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleContextKey).(string)
+	return role
+}
+
+// checkAccountActive fails for accounts that are still pending approval,
+// were rejected, or have been frozen or closed.
+func checkAccountActive(acc *Account) error {
+	switch acc.Status {
+	case AccountStatusActive:
+		return nil
+	case AccountStatusPending:
+		return newStatusError(http.StatusForbidden, "account is pending approval")
+	case AccountStatusFrozen:
+		return newStatusError(http.StatusForbidden, "account is frozen")
+	case AccountStatusClosed:
+		return newStatusError(http.StatusForbidden, "account is closed")
+	default:
+		return newStatusError(http.StatusForbidden, "account is not active")
+	}
+}
+
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, errPermissionDenied)
+}
+
+// jwtSecret signs and verifies tokens. It is set once from the config at
+// startup.
+var jwtSecret string
 
+func setJWTSecret(secret string) {
+	jwtSecret = secret
+}
+
+func validateJWT(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(secret), nil
+		return []byte(jwtSecret), nil
 	})
 }
 
 func createJWT(account *Account) (string, error) {
 	claims := &jwt.MapClaims{
-		"exp":           time.Now().Add(time.Minute * 1).Unix(),
+		"exp":           time.Now().Add(tokenTTL).Unix(),
+		"iat":           time.Now().Unix(),
 		"accountNumber": account.Number,
+		"role":          account.Role,
 	}
 
-	secret := os.Getenv("JWT_SECRET")
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString([]byte(secret))
+	return token.SignedString([]byte(jwtSecret))
+}
+
+// issuedBefore reports whether the token was issued at or before the token
+// epoch. iat only has second precision, so a token issued in the same
+// second as a bump counts as revoked; tokens without iat predate the claim
+// and are revoked by any bump.
+func issuedBefore(claims jwt.MapClaims, epoch time.Time) bool {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return epoch.Unix() > 0
+	}
+	return int64(iat) <= epoch.Unix()
 }
 
 func getID(r *http.Request) (int, error) {
@@ -249,3 +1284,62 @@ func getID(r *http.Request) (int, error) {
 	}
 	return id, nil
 }
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getMetadataFilter reads the metadata_key and metadata_value query
+// parameters of a transaction listing. It returns nil when no key is given.
+func getMetadataFilter(r *http.Request) Metadata {
+	key := r.URL.Query().Get("metadata_key")
+	if key == "" {
+		return nil
+	}
+	return Metadata{key: r.URL.Query().Get("metadata_value")}
+}
+
+// getTimeParam reads an RFC3339 timestamp from the query string, returning
+// fallback when the parameter is absent.
+func getTimeParam(r *http.Request, name string, fallback time.Time) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return fallback, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s given %s: must be an RFC3339 timestamp", name, v)
+	}
+	return t.UTC(), nil
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// getPagination reads the limit and offset query parameters of a list
+// request, applying the default page size when limit is omitted.
+func getPagination(r *http.Request) (int, int, error) {
+	limit, offset := defaultPageLimit, 0
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return 0, 0, fmt.Errorf("invalid limit given %s: must be between 1 and %d", v, maxPageLimit)
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset given %s", v)
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveTest sends one request through the server's full handler stack,
+// authenticated as token unless it is empty.
+func serveTest(server *ApiServer, method, path, token, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("x-jwt-token", token)
+	}
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// testToken returns a JWT for acc.
+func testToken(t *testing.T, acc *Account) string {
+	t.Helper()
+	setJWTSecret("test-secret")
+	token, err := createJWT(acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestHandleTransferChecksDestination(t *testing.T) {
+	store := NewMemoryStore()
+	from := newTestAccount(t, store, 10000)
+	to := &Account{Number: "1234567897", Role: RoleUser, Status: AccountStatusActive}
+	if err := store.CreateAccount(context.Background(), to); err != nil {
+		t.Fatal(err)
+	}
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatNumeric, MinTransferAmount: 1})
+	token := testToken(t, from)
+
+	tests := []struct {
+		name       string
+		to         string
+		wantStatus int
+		wantInBody string
+	}{
+		{"valid", "1234567897", http.StatusOK, `"to":"1234567897"`},
+		{"typo", "1234567987", http.StatusBadRequest, "checksum mismatch"},
+		{"too short", "123456789", http.StatusBadRequest, "must be 10 digits"},
+		{"valid but unknown", "1000000008", http.StatusNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(server, http.MethodPost, "/transfer", token, `{"to_account":"`+tt.to+`","amount":100}`)
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantInBody) {
+				t.Errorf("got %d %s, want %d with %q", rec.Code, rec.Body, tt.wantStatus, tt.wantInBody)
+			}
+		})
+	}
+}
+
+func TestHandleTransferRefusesOverflow(t *testing.T) {
+	store := NewMemoryStore()
+	from := newTestAccount(t, store, 100)
+	to := newTestAccount(t, store, math.MaxInt64)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID, MinTransferAmount: 1})
+
+	rec := serveTest(server, http.MethodPost, "/transfer", testToken(t, from), `{"to_account":"`+to.Number+`","amount":1}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), ErrAmountOverflow.Error()) {
+		t.Fatalf("got %d %s, want 422 with %q", rec.Code, rec.Body, ErrAmountOverflow.Error())
+	}
+	acc, err := store.GetAccountByNumber(context.Background(), from.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Balance != 100 {
+		t.Errorf("sender balance = %d after a refused transfer, want 100", acc.Balance)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type body struct {
+		Name   string `json:"name"`
+		Amount Money  `json:"amount"`
+		Count  int    `json:"count"`
+	}
+	tests := []struct {
+		name         string
+		body         string
+		wantErr      string
+		wantField    string
+		wantExpected string
+	}{
+		{name: "valid", body: `{"name":"ada","count":2}`},
+		{name: "trailing whitespace", body: "{\"name\":\"ada\"}\n\t "},
+		{name: "empty", body: "", wantErr: errBodyRequired.Error()},
+		{name: "whitespace only", body: " \n", wantErr: errBodyRequired.Error()},
+		{name: "two values", body: `{"name":"a"}{"name":"b"}`, wantErr: "single JSON value"},
+		{name: "truncated", body: `{"name":"ada"`, wantErr: "truncated JSON"},
+		{name: "malformed", body: `{"name" "ada"}`, wantErr: "malformed JSON at byte 9"},
+		{name: "not an object", body: `[1,2]`, wantErr: "must be a JSON object", wantExpected: "object"},
+		{name: "wrong type", body: `{"count":"two"}`, wantErr: `field "count" must be int`, wantField: "count", wantExpected: "int"},
+		{name: "bad amount", body: `{"amount":1.5}`, wantErr: "whole number of minor units"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request
+			if tt.body == "" {
+				r = httptest.NewRequest(http.MethodPost, "/", nil)
+			} else {
+				r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			}
+			err := decodeJSON(r, &body{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+			if errorStatus(err) != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", errorStatus(err))
+			}
+			var decodeErr *decodeError
+			if errors.As(err, &decodeErr) {
+				if decodeErr.Field != tt.wantField || decodeErr.Expected != tt.wantExpected {
+					t.Errorf("field %q expected %q, want %q %q", decodeErr.Field, decodeErr.Expected, tt.wantField, tt.wantExpected)
+				}
+			} else if tt.wantField != "" || tt.wantExpected != "" {
+				t.Errorf("got %T, want a *decodeError", err)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorsReachTheClient(t *testing.T) {
+	server := NewApiServer("", NewMemoryStore(), &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+
+	rec := serveTest(server, http.MethodPost, "/accounts", "", `{"first_name":1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var body ApiError
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Field != "first_name" || body.Expected != "string" {
+		t.Errorf("got %+v, want field first_name expecting a string", body)
+	}
+
+	rec = serveTest(server, http.MethodPost, "/accounts", "", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), errBodyRequired.Error()) {
+		t.Errorf("empty body: got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrAPIKeyNotFound = newStatusError(http.StatusNotFound, "api key not found")
+
+// API key scopes. A read key may only make GET requests; write covers
+// every other method, and is needed for transfers.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+const (
+	apiKeyHeader = "x-api-key"
+	// apiKeyPrefix marks the keys so they are easy to spot in logs and
+	// secret scanners.
+	apiKeyPrefix = "gbk_"
+)
+
+const maxAPIKeyNameLength = 64
+
+// APIKey is a long-lived credential for service integrations. Only a hash
+// of the key is stored; the key itself is returned once, on creation.
+type APIKey struct {
+	ID        int64      `json:"id"`
+	AccountID int64      `json:"-"`
+	Name      string     `json:"name"`
+	KeyHash   string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt Timestamp  `json:"created_at"`
+	RevokedAt *Timestamp `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope. Write implies read.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeWrite {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey returns a random key and the hash it is stored and looked up
+// by. The keys carry enough entropy that a plain SHA-256 is safe, unlike
+// passwords.
+func newAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(b)
+	return key, hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope is the scope a request needs when made with an API key.
+func requiredScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// withAPIKey is withAuth for callers presenting an API key instead of a
+// JWT. The key stands in for its account, limited to its scopes and to the
+// user role: admin routes need an admin's login token, so a leaked key
+// cannot reach them. Keys created before the token epoch are void, like
+// the tokens issued before it, so revoking every session revokes them too.
+func withAPIKey(handlerFunc http.HandlerFunc, store Storage, key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey, err := store.GetAPIKeyByHash(r.Context(), hashAPIKey(key))
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			WriteError(w, r, errInvalidToken)
+			return
+		}
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		epoch, err := store.GetTokenEpoch(r.Context())
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if !apiKey.CreatedAt.Time.After(epoch) {
+			WriteError(w, r, errInvalidToken)
+			return
+		}
+		if !apiKey.HasScope(requiredScope(r)) {
+			WriteError(w, r, statusErrorf(http.StatusForbidden, "api key lacks the %s scope", requiredScope(r)))
+			return
+		}
+
+		account, err := store.GetAccountByID(r.Context(), int(apiKey.AccountID))
+		if errors.Is(err, ErrCircuitOpen) {
+			WriteError(w, r, err)
+			return
+		}
+		if err != nil {
+			permissionDenied(w, r)
+			return
+		}
+		if err := checkAccountActive(account); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accountContextKey, account)
+		ctx = context.WithValue(ctx, roleContextKey, RoleUser)
+		ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	apiKey, _ := ctx.Value(apiKeyContextKey).(*APIKey)
+	return apiKey
+}
+
+// requireJWT keeps API keys from managing API keys, so a leaked key
+// cannot mint itself successors or outlive its revocation.
+func requireJWT(r *http.Request) error {
+	if apiKeyFromContext(r.Context()) != nil {
+		return newStatusError(http.StatusForbidden, "api keys are managed with a login token, not an api key")
+	}
+	return nil
+}
+
+func (s *ApiServer) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	if err := requireJWT(r); err != nil {
+		return err
+	}
+	account := accountFromContext(r.Context())
+
+	keys, err := s.store.GetAPIKeys(r.Context(), account.ID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, keys)
+}
+
+func (s *ApiServer) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) error {
+	if err := requireJWT(r); err != nil {
+		return err
+	}
+
+	var req CreateAPIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("name must be between 1 and %d characters", maxAPIKeyNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("scopes is required")
+	}
+	for _, scope := range req.Scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return fmt.Errorf("invalid scope given %q: must be %q or %q", scope, ScopeRead, ScopeWrite)
+		}
+	}
+
+	key, hash, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	apiKey := &APIKey{
+		AccountID: accountFromContext(r.Context()).ID,
+		Name:      req.Name,
+		KeyHash:   hash,
+		Scopes:    req.Scopes,
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	if err := s.store.CreateAPIKey(r.Context(), apiKey); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+func (s *ApiServer) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	if err := requireJWT(r); err != nil {
+		return err
+	}
+
+	idStr := mux.Vars(r)["keyID"]
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid api key id given %s", idStr)
+	}
+
+	account := accountFromContext(r.Context())
+	if err := s.store.RevokeAPIKey(r.Context(), account.ID, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
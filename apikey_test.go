@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPIKey creates a key for acc with scopes, created at createdAt,
+// and returns the key itself.
+func newTestAPIKey(t *testing.T, store Storage, acc *Account, createdAt time.Time, scopes ...string) string {
+	t.Helper()
+	key, hash, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := &APIKey{AccountID: acc.ID, Name: "test", KeyHash: hash, Scopes: scopes, CreatedAt: NewTimestamp(createdAt)}
+	if err := store.CreateAPIKey(context.Background(), apiKey); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWithAPIKey(t *testing.T) {
+	store := NewMemoryStore()
+	user := newTestAccount(t, store, 0)
+	admin := &Account{Number: "admin", Role: RoleAdmin, Status: AccountStatusActive}
+	if err := store.CreateAccount(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	readKey := newTestAPIKey(t, store, user, now, ScopeRead)
+	writeKey := newTestAPIKey(t, store, user, now, ScopeWrite)
+	adminKey := newTestAPIKey(t, store, admin, now, ScopeWrite)
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		key     string
+		want    int
+		wantErr string
+	}{
+		{"read key reads", withAuth(ok, store), http.MethodGet, readKey, http.StatusNoContent, ""},
+		{"read key cannot write", withAuth(ok, store), http.MethodPost, readKey, http.StatusForbidden, "lacks the write scope"},
+		{"write key writes", withAuth(ok, store), http.MethodPost, writeKey, http.StatusNoContent, ""},
+		{"unknown key", withAuth(ok, store), http.MethodGet, apiKeyPrefix + "nope", http.StatusForbidden, errInvalidToken.Error()},
+		{"admin key on an admin route", withAdmin(ok, store), http.MethodGet, adminKey, http.StatusForbidden, errPermissionDenied.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set(apiKeyHeader, tt.key)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("got status %d, want %d with %q: %s", rec.Code, tt.want, tt.wantErr, rec.Body)
+			}
+		})
+	}
+}
+
+func TestWithAPIKeyRevokedByTokenEpoch(t *testing.T) {
+	store := NewMemoryStore()
+	user := newTestAccount(t, store, 0)
+	key := newTestAPIKey(t, store, user, time.Now().Add(-time.Minute), ScopeRead)
+	if _, err := store.BumpTokenEpoch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(apiKeyHeader, key)
+	rec := httptest.NewRecorder()
+	withAuth(func(w http.ResponseWriter, r *http.Request) {}, store)(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), errInvalidToken.Error()) {
+		t.Errorf("key from before the epoch got status %d: %s", rec.Code, rec.Body)
+	}
+}
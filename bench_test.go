@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The benchmarks run with `make bench`, or `go test -run '^$' -bench .
+// -benchmem`. BenchmarkTransferPostgres only runs when
+// GOBANK_TEST_DATABASE_URL names a database, like the other store tests.
+
+func BenchmarkTransferMemory(b *testing.B) {
+	store := NewMemoryStore()
+	benchmarkTransfer(b, store, newTestAccount(b, store, 1<<62), newTestAccount(b, store, 0))
+}
+
+func BenchmarkTransferPostgres(b *testing.B) {
+	store := newTestStore(b)
+	benchmarkTransfer(b, store, newTestAccount(b, store, 1<<40), newTestAccount(b, store, 0))
+}
+
+// benchmarkTransfer posts transfers of 1 from one account to the other
+// through the full handler chain, authentication and JSON included, and
+// reports the rate next to the usual ns/op and allocations.
+func benchmarkTransfer(b *testing.B, store Storage, from, to *Account) {
+	setJWTSecret("test-secret")
+	token, err := createJWT(from)
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID, MinTransferAmount: 1}
+	handler := NewApiServer("", store, config).Handler()
+	body := []byte(fmt.Sprintf(`{"to_account":%q,"amount":1}`, to.Number))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/transfer", bytes.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("transfer failed with status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "transfers/s")
+}
+
+// BenchmarkWriteJSONAccounts encodes account list pages the way GET
+// /accounts answers them.
+func BenchmarkWriteJSONAccounts(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		accounts := make([]*Account, n)
+		for i := range accounts {
+			accounts[i] = &Account{
+				ID:        int64(i + 1),
+				FirstName: "Ada",
+				LastName:  "Lovelace",
+				Number:    uuid.NewString(),
+				Balance:   Money(i * 100),
+				Role:      RoleUser,
+				Type:      AccountTypeChecking,
+				Status:    AccountStatusActive,
+				CreatedAt: NewTimestamp(time.Now()),
+			}
+		}
+		page := ListResponse{Data: accounts, Total: n, Limit: n}
+
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := WriteJSON(discardResponseWriter{header: http.Header{}}, http.StatusOK, page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// discardResponseWriter throws the body away, so the benchmark measures
+// encoding rather than a growing recorder buffer.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrBeneficiaryNotFound = newStatusError(http.StatusNotFound, "beneficiary not found")
+	ErrBeneficiaryExists   = newStatusError(http.StatusConflict, "account is already a saved beneficiary")
+)
+
+const maxNicknameLength = 64
+
+// Beneficiary is a recipient an account owner saved to transfer to by id
+// instead of typing the account number each time.
+type Beneficiary struct {
+	ID        int64     `json:"id"`
+	AccountID int64     `json:"-"`
+	Nickname  string    `json:"nickname"`
+	Number    string    `json:"number"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type CreateBeneficiaryRequest struct {
+	Nickname string `json:"nickname"`
+	Number   string `json:"number"`
+}
+
+func (s *ApiServer) handleGetBeneficiaries(w http.ResponseWriter, r *http.Request) error {
+	account := accountFromContext(r.Context())
+
+	beneficiaries, err := s.store.GetBeneficiaries(r.Context(), account.ID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, beneficiaries)
+}
+
+func (s *ApiServer) handleCreateBeneficiary(w http.ResponseWriter, r *http.Request) error {
+	var req CreateBeneficiaryRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	if req.Nickname == "" || len(req.Nickname) > maxNicknameLength {
+		return fmt.Errorf("nickname must be between 1 and %d characters", maxNicknameLength)
+	}
+	if err := checkDestinationNumber(s.config.AccountNumberFormat, s.config.AccountNumberPrefix, req.Number); err != nil {
+		return err
+	}
+
+	account := accountFromContext(r.Context())
+	if req.Number == account.Number {
+		return fmt.Errorf("cannot save your own account as a beneficiary")
+	}
+	if _, err := s.store.GetAccountByNumber(r.Context(), req.Number); err != nil {
+		return err
+	}
+
+	beneficiary := &Beneficiary{
+		AccountID: account.ID,
+		Nickname:  req.Nickname,
+		Number:    req.Number,
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	if err := s.store.CreateBeneficiary(r.Context(), beneficiary); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusCreated, beneficiary)
+}
+
+func (s *ApiServer) handleDeleteBeneficiary(w http.ResponseWriter, r *http.Request) error {
+	id, err := getBeneficiaryID(r)
+	if err != nil {
+		return err
+	}
+
+	account := accountFromContext(r.Context())
+	if err := s.store.DeleteBeneficiary(r.Context(), account.ID, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func getBeneficiaryID(r *http.Request) (int64, error) {
+	idStr := mux.Vars(r)["beneficiaryID"]
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid beneficiary id given %s", idStr)
+	}
+	return id, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrCircuitOpen = newStatusError(http.StatusServiceUnavailable, "database unavailable, try again later")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker stops calls to the database after threshold consecutive
+// failures, so an overwhelmed Postgres gets room to recover instead of a
+// growing pile of requests. After cooldown a single probe call is let
+// through: success closes the breaker again, failure reopens it.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a breaker that opens after threshold failures.
+// A threshold of zero or less disables it.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns ErrCircuitOpen when calls should fail fast. Every allowed
+// call must be followed by Record.
+func (b *CircuitBreaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// Open reports whether the breaker is refusing calls, without claiming the
+// probe the way Allow does once the cooldown is over.
+func (b *CircuitBreaker) Open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return time.Since(b.openedAt) < b.cooldown
+	case breakerHalfOpen:
+		return b.probing
+	}
+	return false
+}
+
+// Record feeds the outcome of an allowed call back into the breaker.
+func (b *CircuitBreaker) Record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isDBFailure(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isDBFailure tells errors that say the database is unhealthy apart from
+// ones caused by the query itself, like a missing row or a constraint
+// violation, which say nothing about the database's health.
+func isDBFailure(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53", "57", "58":
+			// connection exception, insufficient resources, operator
+			// intervention and system error
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// rowScanner is the part of *sql.Row the store uses, letting breakerDB hand
+// back a row that fails with ErrCircuitOpen without touching the database.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
+
+type breakerRow struct {
+	row     *sql.Row
+	breaker *CircuitBreaker
+}
+
+func (r breakerRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	r.breaker.Record(err)
+	return err
+}
+
+// breakerDB runs the statements PostgresStore issues through the circuit
+// breaker, and logs those slower than slowQuery. Statements inside a
+// transaction are covered by BeginTx and withTx.
+type breakerDB struct {
+	*sql.DB
+	breaker   *CircuitBreaker
+	slowQuery time.Duration
+}
+
+func (d *breakerDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if err := d.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	logSlowQuery(query, len(args), time.Since(start), d.slowQuery)
+	d.breaker.Record(err)
+	return rows, err
+}
+
+func (d *breakerDB) QueryRowContext(ctx context.Context, query string, args ...any) rowScanner {
+	if err := d.breaker.Allow(); err != nil {
+		return errRow{err: err}
+	}
+	start := time.Now()
+	row := d.DB.QueryRowContext(ctx, query, args...)
+	logSlowQuery(query, len(args), time.Since(start), d.slowQuery)
+	return breakerRow{row: row, breaker: d.breaker}
+}
+
+func (d *breakerDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := d.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := d.DB.ExecContext(ctx, query, args...)
+	logSlowQuery(query, len(args), time.Since(start), d.slowQuery)
+	d.breaker.Record(err)
+	return res, err
+}
+
+func (d *breakerDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if err := d.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	tx, err := d.DB.BeginTx(ctx, opts)
+	d.breaker.Record(err)
+	return tx, err
+}
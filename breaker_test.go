@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	dbDown := errors.New("connection refused")
+
+	// Each step is one of: "allow" and "refuse", checking what Allow
+	// returns; "fail" and "succeed", recording an outcome; and "cool",
+	// moving past the cooldown.
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []string
+		wantState breakerState
+	}{
+		{"stays closed below the threshold", 3, []string{"allow", "fail", "allow", "fail", "allow"}, breakerClosed},
+		{"success resets the count", 2, []string{"allow", "fail", "allow", "succeed", "allow", "fail", "allow"}, breakerClosed},
+		{"opens at the threshold", 2, []string{"allow", "fail", "allow", "fail", "refuse", "refuse"}, breakerOpen},
+		{"half-open lets one probe through", 1, []string{"allow", "fail", "refuse", "cool", "allow", "refuse"}, breakerHalfOpen},
+		{"probe success closes", 1, []string{"allow", "fail", "cool", "allow", "succeed", "allow", "allow"}, breakerClosed},
+		{"probe failure reopens", 3, []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "fail", "refuse"}, breakerOpen},
+		{"disabled never opens", 0, []string{"allow", "fail", "allow", "fail", "allow", "fail", "allow"}, breakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(tt.threshold, time.Minute)
+			for i, step := range tt.steps {
+				switch step {
+				case "allow", "refuse":
+					err := b.Allow()
+					if want := step == "refuse"; (err != nil) != want || (want && !errors.Is(err, ErrCircuitOpen)) {
+						t.Fatalf("step %d: Allow() = %v, want %s", i, err, step)
+					}
+				case "fail":
+					b.Record(dbDown)
+				case "succeed":
+					b.Record(nil)
+				case "cool":
+					b.openedAt = b.openedAt.Add(-b.cooldown)
+					if b.Open() {
+						t.Fatalf("step %d: Open() after the cooldown", i)
+					}
+				}
+			}
+			if b.state != tt.wantState {
+				t.Errorf("state = %d, want %d", b.state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestIsDBFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"wrapped no rows", fmt.Errorf("get account: %w", sql.ErrNoRows), false},
+		{"canceled", context.Canceled, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"syntax error", &pq.Error{Code: "42601"}, false},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"too many connections", &pq.Error{Code: "53300"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"io error", &pq.Error{Code: "58030"}, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"network", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDBFailure(tt.err); got != tt.want {
+				t.Errorf("isDBFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrAccountNotRestorable is returned for restores of accounts that are not
+// closed, or whose grace period is over.
+var ErrAccountNotRestorable = newStatusError(http.StatusConflict, "account is not closed or can no longer be restored")
+
+// PurgeJob anonymizes closed accounts once their grace period is over.
+type PurgeJob struct {
+	store    Storage
+	interval time.Duration
+}
+
+func NewPurgeJob(store Storage, config *Config) *PurgeJob {
+	return &PurgeJob{
+		store:    store,
+		interval: config.AccountPurgeInterval,
+	}
+}
+
+// Run purges the due accounts straight away, then again every interval,
+// until ctx is cancelled.
+func (j *PurgeJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := j.store.PurgeClosedAccounts(ctx, time.Now().UTC())
+		if err != nil {
+			log.Println("account purge failed:", err)
+		}
+		if purged > 0 {
+			log.Println("purged", purged, "closed accounts")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleRestoreAccount reopens an account closed less than the grace
+// period ago, giving it back the status it had before.
+func (s *ApiServer) handleRestoreAccount(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.store.RestoreAccount(r.Context(), id, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	s.localizeAccounts(r, account)
+	return WriteJSON(w, http.StatusOK, account)
+}
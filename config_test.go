@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// loadTestConfig runs LoadConfig with env set on top of the minimum it
+// needs to succeed.
+func loadTestConfig(t *testing.T, env map[string]string) (*Config, error) {
+	t.Helper()
+	t.Setenv("POSTGRES_URL", "postgres://gobank@localhost:5432/gobank?sslmode=disable")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return LoadConfig()
+}
+
+func TestLoadConfigCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantOrigins []string
+		wantErr     string
+	}{
+		{name: "unset", env: map[string]string{}, wantOrigins: []string{}},
+		{name: "origins", env: map[string]string{"CORS_ALLOWED_ORIGINS": " https://a.example , ,https://b.example"}, wantOrigins: []string{"https://a.example", "https://b.example"}},
+		{name: "credentials with origins", env: map[string]string{"CORS_ALLOWED_ORIGINS": "https://a.example", "CORS_ALLOW_CREDENTIALS": "true"}, wantOrigins: []string{"https://a.example"}},
+		{name: "wildcard", env: map[string]string{"CORS_ALLOWED_ORIGINS": "*"}, wantOrigins: []string{"*"}},
+		{name: "credentials with wildcard", env: map[string]string{"CORS_ALLOWED_ORIGINS": "https://a.example,*", "CORS_ALLOW_CREDENTIALS": "true"}, wantErr: "cannot be used with the \"*\" origin"},
+		{name: "negative max age", env: map[string]string{"CORS_MAX_AGE": "-1"}, wantErr: "CORS_MAX_AGE must not be negative"},
+		{name: "max age not a number", env: map[string]string{"CORS_MAX_AGE": "1h"}, wantErr: "CORS_MAX_AGE must be an integer"},
+		{name: "credentials not a boolean", env: map[string]string{"CORS_ALLOW_CREDENTIALS": "sometimes"}, wantErr: "CORS_ALLOW_CREDENTIALS must be a boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(cfg.CORSAllowedOrigins, " ") != strings.Join(tt.wantOrigins, " ") {
+				t.Errorf("origins = %q, want %q", cfg.CORSAllowedOrigins, tt.wantOrigins)
+			}
+		})
+	}
+}
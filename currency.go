@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency with its exponent, the number of minor
+// unit digits: 2 for USD, where 100 is $1.00, but 0 for JPY, where 100 is
+// ¥100.
+//
+// Amounts travel through the API and the database as whole minor units,
+// so the exponent never enters parsing; it matters wherever an amount is
+// shown to a person, in formatted balances, statements and error messages.
+type Currency struct {
+	Code     string `json:"code"`
+	Exponent int    `json:"exponent"`
+}
+
+// currencies is the ISO 4217 table the configured currency is checked
+// against at startup.
+var currencies = map[string]Currency{}
+
+func init() {
+	for exponent, codes := range map[int][]string{
+		0: {"BIF", "CLP", "DJF", "GNF", "ISK", "JPY", "KMF", "KRW", "PYG", "RWF", "UGX", "UYI", "VND", "VUV", "XAF", "XOF", "XPF"},
+		2: {
+			"AED", "ARS", "AUD", "BGN", "BRL", "CAD", "CHF", "CNY", "COP", "CZK", "DKK", "EGP", "EUR", "GBP", "HKD",
+			"HUF", "IDR", "ILS", "INR", "KES", "MAD", "MXN", "MYR", "NGN", "NOK", "NZD", "PEN", "PHP", "PKR", "PLN",
+			"RON", "SAR", "SEK", "SGD", "THB", "TRY", "TWD", "UAH", "USD", "ZAR",
+		},
+		3: {"BHD", "IQD", "JOD", "KWD", "LYD", "OMR", "TND"},
+	} {
+		for _, code := range codes {
+			currencies[code] = Currency{Code: code, Exponent: exponent}
+		}
+	}
+}
+
+func lookupCurrency(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("unknown currency %q", code)
+	}
+	return c, nil
+}
+
+// Format renders an amount in minor units with the locale's separators,
+// such as "-1.234,56" for -123456 USD in German.
+func (c Currency) Format(amount Money, l moneyLocale) string {
+	sign := ""
+	units := int64(amount)
+	if units < 0 {
+		sign, units = "-", -units
+	}
+
+	digits := strconv.FormatInt(units, 10)
+	if len(digits) <= c.Exponent {
+		digits = strings.Repeat("0", c.Exponent-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-c.Exponent], digits[len(digits)-c.Exponent:]
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.group)
+		}
+		b.WriteRune(d)
+	}
+	if c.Exponent > 0 {
+		b.WriteString(l.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestCurrencyFormat(t *testing.T) {
+	en, de := moneyLocales["en"], moneyLocales["de"]
+	tests := []struct {
+		code   string
+		amount Money
+		loc    moneyLocale
+		want   string
+	}{
+		{"JPY", 0, en, "0"},
+		{"JPY", 100, en, "100"},
+		{"JPY", 1234567, en, "1,234,567"},
+		{"JPY", -1234567, de, "-1.234.567"},
+		{"USD", 0, en, "0.00"},
+		{"USD", 5, en, "0.05"},
+		{"USD", 100, en, "1.00"},
+		{"USD", 123456, en, "1,234.56"},
+		{"USD", -123456, de, "-1.234,56"},
+		{"USD", 9223372036854775807, en, "92,233,720,368,547,758.07"},
+		{"KWD", 5, en, "0.005"},
+		{"KWD", 1000, en, "1.000"},
+		{"KWD", 1234567, de, "1.234,567"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code+" "+tt.want, func(t *testing.T) {
+			c, err := lookupCurrency(tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Format(tt.amount, tt.loc); got != tt.want {
+				t.Errorf("Format(%d) = %q, want %q", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupCurrency(t *testing.T) {
+	tests := []struct {
+		code         string
+		wantExponent int
+		wantErr      bool
+	}{
+		{code: "JPY", wantExponent: 0},
+		{code: "usd", wantExponent: 2},
+		{code: "KWD", wantExponent: 3},
+		{code: "XYZ", wantErr: true},
+		{code: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			c, err := lookupCurrency(tt.code)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Exponent != tt.wantExponent {
+				t.Errorf("exponent = %d, want %d", c.Exponent, tt.wantExponent)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// apiError is implemented by errors that know which HTTP status they
+// should be reported with. WriteError finds it anywhere in a wrapped chain.
+type apiError interface {
+	error
+	StatusCode() int
+}
+
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+func newStatusError(status int, msg string) error {
+	return &statusError{status: status, msg: msg}
+}
+
+func statusErrorf(status int, format string, args ...any) error {
+	return &statusError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// decodeError is a request body that is not the JSON the handler expects,
+// with where the problem is for clients to point at.
+type decodeError struct {
+	msg      string
+	Field    string
+	Offset   int64
+	Expected string
+}
+
+func (e *decodeError) Error() string {
+	return e.msg
+}
+
+func (e *decodeError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// deleteBatchError is a batch delete refused as a whole, carrying what
+// would have happened to each account so clients can see which ones held
+// funds.
+type deleteBatchError struct {
+	err     error
+	Results []*DeleteResult
+}
+
+func (e *deleteBatchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deleteBatchError) Unwrap() error {
+	return e.err
+}
+
+func (e *deleteBatchError) StatusCode() int {
+	return errorStatus(e.err)
+}
+
+var (
+	errPermissionDenied = newStatusError(http.StatusForbidden, "permission denied")
+	errInvalidToken     = newStatusError(http.StatusForbidden, "invalid token")
+	errBodyRequired     = newStatusError(http.StatusBadRequest, "request body required")
+	errServerBusy       = newStatusError(http.StatusServiceUnavailable, "server busy, try again shortly")
+	errRequestTimeout   = newStatusError(http.StatusServiceUnavailable, "request timed out")
+)
+
+// errorStatus maps err to the status it should be reported with. Errors
+// without a status are treated as bad requests.
+func errorStatus(err error) int {
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode()
+	}
+	return http.StatusBadRequest
+}
+
+// WriteError writes err with the status derived from it, logging server
+// side failures. The body is the ApiError envelope unless the client or
+// the configuration asked for RFC 7807 problem details.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) error {
+	err = schemaError(err)
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("request failed with %d: %v", status, err)
+	}
+	if wantsProblemDetails(r) {
+		return writeProblem(w, r, status, err)
+	}
+	body := ApiError{Error: err.Error()}
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		body.Field = decodeErr.Field
+		body.Offset = decodeErr.Offset
+		body.Expected = decodeErr.Expected
+	}
+	var batchErr *deleteBatchError
+	if errors.As(err, &batchErr) {
+		body.Results = batchErr.Results
+	}
+	return WriteJSON(w, status, body)
+}
+
+const problemContentType = "application/problem+json"
+
+// ProblemDetails is the RFC 7807 error body.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Field, Offset and Expected extend the problem for bodies that
+	// failed to decode.
+	Field    string `json:"field,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Expected string `json:"expected,omitempty"`
+
+	// Results extends the problem for a refused batch delete.
+	Results []*DeleteResult `json:"results,omitempty"`
+}
+
+type problemType struct {
+	err   error
+	slug  string
+	title string
+}
+
+// problemTypes names the errors clients may want to tell apart. Anything
+// else is reported as about:blank titled with the status text. It is a
+// slice so that an error wrapping more than one of these always gets the
+// first one listed.
+var problemTypes = []problemType{
+	{ErrAccountNotFound, "account-not-found", "Account not found"},
+	{ErrAccountHasFunds, "account-has-funds", "Account still holds funds"},
+	{ErrAccountNotPending, "account-not-pending", "Account is not pending approval"},
+	{ErrAccountNotRestorable, "account-not-restorable", "Account cannot be restored"},
+	{ErrInsufficientFunds, "insufficient-funds", "Insufficient funds"},
+	{ErrSystemAccount, "system-account", "System account"},
+	{ErrBeneficiaryNotFound, "beneficiary-not-found", "Beneficiary not found"},
+	{ErrBeneficiaryExists, "beneficiary-exists", "Beneficiary already saved"},
+	{ErrAPIKeyNotFound, "api-key-not-found", "API key not found"},
+	{ErrWhitelistEntryNotFound, "whitelist-entry-not-found", "Whitelist entry not found"},
+	{ErrWhitelistEntryExists, "whitelist-entry-exists", "Destination already whitelisted"},
+	{ErrNotWhitelisted, "not-whitelisted", "Destination not whitelisted"},
+	{ErrCircuitOpen, "database-unavailable", "Database unavailable"},
+	{ErrSchemaMissing, "schema-missing", "Database schema missing"},
+	{errPermissionDenied, "permission-denied", "Permission denied"},
+	{errInvalidToken, "invalid-token", "Invalid token"},
+	{errBodyRequired, "body-required", "Request body required"},
+	{errServerBusy, "server-busy", "Server busy"},
+	{errRequestTimeout, "request-timeout", "Request timed out"},
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, err error) error {
+	problem := ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		problem.Type = "urn:gobank:problem:malformed-body"
+		problem.Title = "Malformed request body"
+		problem.Field = decodeErr.Field
+		problem.Offset = decodeErr.Offset
+		problem.Expected = decodeErr.Expected
+	}
+	var batchErr *deleteBatchError
+	if errors.As(err, &batchErr) {
+		problem.Results = batchErr.Results
+	}
+	for _, t := range problemTypes {
+		if errors.Is(err, t.err) {
+			problem.Type = "urn:gobank:problem:" + t.slug
+			problem.Title = t.title
+			break
+		}
+	}
+
+	return writeEncoded(w, status, problemContentType, problem)
+}
+
+type errorFormatContextKey struct{}
+
+// withErrorFormat records whether errors on this server default to
+// problem details, for WriteError to pick up from the request.
+func withErrorFormat(next http.Handler, config *Config) http.Handler {
+	if !config.ProblemDetails {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), errorFormatContextKey{}, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// wantsProblemDetails reports whether the error for r should be problem
+// details: either the server defaults to them and r does not want legacy
+// responses, or the client asked for them.
+func wantsProblemDetails(r *http.Request) bool {
+	if on, _ := r.Context().Value(errorFormatContextKey{}).(bool); on && responseShape(r) != ResponseShapeLegacy {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), problemContentType)
+}
+
+// Response shapes, see Config.ResponseShape.
+const (
+	ResponseShapeEnveloped = "enveloped"
+	ResponseShapeLegacy    = "legacy"
+
+	responseShapeHeader = "X-Response-Shape"
+)
+
+type responseShapeContextKey struct{}
+
+// withResponseShape records the server's default response shape, for the
+// write helpers to pick up from the request.
+func withResponseShape(next http.Handler, config *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseShapeContextKey{}, config.ResponseShape)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// responseShape is the shape to answer r in: the one its X-Response-Shape
+// header names, or else the server's default. Unknown header values are
+// ignored rather than failing the request.
+func responseShape(r *http.Request) string {
+	switch shape := strings.ToLower(r.Header.Get(responseShapeHeader)); shape {
+	case ResponseShapeEnveloped, ResponseShapeLegacy:
+		return shape
+	}
+	if shape, ok := r.Context().Value(responseShapeContextKey{}).(string); ok {
+		return shape
+	}
+	return ResponseShapeEnveloped
+}
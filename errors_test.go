@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorProblemDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   string
+		wantTitle  string
+	}{
+		{
+			name:       "plain error",
+			err:        errors.New("bad input"),
+			wantStatus: http.StatusBadRequest,
+			wantType:   "about:blank",
+			wantTitle:  "Bad Request",
+		},
+		{
+			name:       "sentinel",
+			err:        ErrAccountNotFound,
+			wantStatus: http.StatusNotFound,
+			wantType:   "urn:gobank:problem:account-not-found",
+			wantTitle:  "Account not found",
+		},
+		{
+			name:       "wrapped sentinel",
+			err:        fmt.Errorf("transfer: %w", ErrInsufficientFunds),
+			wantStatus: errorStatus(ErrInsufficientFunds),
+			wantType:   "urn:gobank:problem:insufficient-funds",
+			wantTitle:  "Insufficient funds",
+		},
+		{
+			name:       "two sentinels take the first listed",
+			err:        errors.Join(errPermissionDenied, ErrAccountNotFound),
+			wantStatus: http.StatusForbidden,
+			wantType:   "urn:gobank:problem:account-not-found",
+			wantTitle:  "Account not found",
+		},
+		{
+			name:       "status without a type",
+			err:        newStatusError(http.StatusConflict, "conflict"),
+			wantStatus: http.StatusConflict,
+			wantType:   "about:blank",
+			wantTitle:  "Conflict",
+		},
+		{
+			name:       "malformed body",
+			err:        &decodeError{msg: "amount must be a number", Field: "amount"},
+			wantStatus: http.StatusBadRequest,
+			wantType:   "urn:gobank:problem:malformed-body",
+			wantTitle:  "Malformed request body",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Run each case a few times: the lookup must not depend on
+			// iteration order.
+			for i := 0; i < 20; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+				r.Header.Set("Accept", problemContentType)
+				w := httptest.NewRecorder()
+				if err := WriteError(w, r, tt.err); err != nil {
+					t.Fatal(err)
+				}
+
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+					t.Fatalf("Content-Type = %q, want %q", ct, problemContentType)
+				}
+				var problem ProblemDetails
+				if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+					t.Fatal(err)
+				}
+				if problem.Type != tt.wantType || problem.Title != tt.wantTitle {
+					t.Fatalf("got %q %q, want %q %q", problem.Type, problem.Title, tt.wantType, tt.wantTitle)
+				}
+				if problem.Status != tt.wantStatus || problem.Instance != "/account/1" || problem.Detail != tt.err.Error() {
+					t.Fatalf("got %+v", problem)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteErrorLegacyEnvelope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+	w := httptest.NewRecorder()
+	if err := WriteError(w, r, ErrAccountNotFound); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	var body ApiError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != ErrAccountNotFound.Error() {
+		t.Fatalf("error = %q, want %q", body.Error, ErrAccountNotFound.Error())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Features are groups of endpoints an operator can switch off with
+// DISABLED_FEATURES, for example to stop transfers during an incident.
+const (
+	FeatureSignup        = "signup"
+	FeatureTransfers     = "transfers"
+	FeatureSweep         = "sweep"
+	FeatureBeneficiaries = "beneficiaries"
+	FeatureBalanceAsOf   = "balance_as_of"
+)
+
+// features is the registry of every feature name DISABLED_FEATURES may
+// list.
+var features = map[string]bool{
+	FeatureSignup:        true,
+	FeatureTransfers:     true,
+	FeatureSweep:         true,
+	FeatureBeneficiaries: true,
+	FeatureBalanceAsOf:   true,
+}
+
+// featureNames returns the registered features in order, for error
+// messages.
+func featureNames() []string {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withFeature answers DisabledFeatureStatus instead of calling next when
+// feature is disabled. It runs before authentication, so a disabled
+// endpoint looks the same to every caller.
+func (s *ApiServer) withFeature(feature string, next http.HandlerFunc) http.HandlerFunc {
+	if !s.disabled[feature] {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.DisabledFeatureStatus == http.StatusNotFound {
+			WriteError(w, r, statusErrorf(http.StatusNotFound, "not found"))
+			return
+		}
+		WriteError(w, r, statusErrorf(s.config.DisabledFeatureStatus, "%s is disabled", feature))
+	}
+}
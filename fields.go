@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// accountFields are the account fields ?fields= may select.
+var accountFields = map[string]bool{
+	"id":                true,
+	"first_name":        true,
+	"last_name":         true,
+	"number":            true,
+	"balance":           true,
+	"balance_formatted": true,
+	"role":              true,
+	"account_type":      true,
+	"status":            true,
+	"created_at":        true,
+	"purge_at":          true,
+}
+
+// getFields reads the comma separated fields query parameter, checking
+// each name against allowed. It returns nil when the parameter is absent,
+// meaning every field.
+func getFields(r *http.Request, allowed map[string]bool) ([]string, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(v, ",") {
+		field = strings.TrimSpace(field)
+		if !allowed[field] {
+			return nil, fmt.Errorf("invalid field given %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// selectFields returns v as a JSON object holding only fields, or v itself
+// when fields is nil. Selected fields that v leaves out, such as an empty
+// balance_formatted, stay out.
+func selectFields(v any, fields []string) (any, error) {
+	if fields == nil {
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
+
+// selectAccountFields applies selectFields to every account in a page.
+func selectAccountFields(accounts []*Account, fields []string) (any, error) {
+	if fields == nil {
+		return accounts, nil
+	}
+
+	selected := make([]any, 0, len(accounts))
+	for _, acc := range accounts {
+		v, err := selectFields(acc, fields)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, v)
+	}
+	return selected, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGetFields(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr string
+	}{
+		{query: "", want: nil},
+		{query: "?fields=number", want: []string{"number"}},
+		{query: "?fields=number,%20balance", want: []string{"number", "balance"}},
+		{query: "?fields=number,encrypted_password", wantErr: `invalid field given "encrypted_password"`},
+		{query: "?fields=number,", wantErr: `invalid field given ""`},
+		{query: "?fields=Number", wantErr: `invalid field given "Number"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := getFields(httptest.NewRequest(http.MethodGet, "/accounts"+tt.query, nil), accountFields)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAccountSelectsFields(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 1234)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+	token := testToken(t, acc)
+
+	tests := []struct {
+		fields     string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{"number,balance", http.StatusOK, []string{"balance", "number"}},
+		{"id", http.StatusOK, []string{"id"}},
+		// balance_formatted is only there with a supported Accept-Language.
+		{"number,balance_formatted", http.StatusOK, []string{"number"}},
+		{"encrypted_password", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			rec := serveTest(server, http.MethodGet, fmt.Sprintf("/accounts/%d?fields=%s", acc.ID, tt.fields), token, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body map[string]json.RawMessage
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for key := range body {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %q, want %q", keys, tt.wantKeys)
+			}
+			if tt.fields == "number,balance" && (string(body["number"]) != `"`+acc.Number+`"` || string(body["balance"]) != "1234") {
+				t.Errorf("got %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The fuzz targets feed arbitrary bodies through the decode and validate
+// steps the handlers run on untrusted JSON. Neither may panic, and
+// whatever passes both has to be a request the handlers can act on. Run
+// one with `go test -run '^$' -fuzz FuzzTransfer`; plain `go test` only
+// replays the seeds.
+
+func FuzzCreateAccount(f *testing.F) {
+	for _, seed := range []string{
+		`{"first_name":"Ada","last_name":"Lovelace","password":"correct horse","initial_balance":100}`,
+		`{"first_name":"Ada","last_name":"Lovelace","account_type":"savings"}`,
+		`{"first_name":" \t","last_name":"Lovelace"}`,
+		`{"first_name":"Ada\u0000","last_name":"‮"}`,
+		`{"first_name":"Ada","last_name":"Lovelace","initial_balance":-1}`,
+		`{"first_name":"Ada","last_name":"Lovelace","initial_balance":1e400}`,
+		`{"first_name":"Ada","last_name":"Lovelace","initial_balance":"NaN"}`,
+		`{"first_name":"Ada","last_name":"Lovelace","account_type":"gold"}`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var req CreateAccountRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return
+		}
+		if err := req.Validate(); err != nil {
+			return
+		}
+
+		checkName(t, "first_name", req.FirstName)
+		checkName(t, "last_name", req.LastName)
+		if req.InitialBalance < 0 {
+			t.Errorf("negative initial balance %d passed validation", req.InitialBalance)
+		}
+		switch req.AccountType {
+		case "", AccountTypeChecking, AccountTypeSavings:
+		default:
+			t.Errorf("account type %q passed validation", req.AccountType)
+		}
+	})
+}
+
+func checkName(t *testing.T, field, name string) {
+	t.Helper()
+	if name == "" || name != strings.TrimSpace(name) {
+		t.Errorf("%s %q passed validation untrimmed or empty", field, name)
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		t.Errorf("%s of %d characters passed validation", field, utf8.RuneCountInString(name))
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		t.Errorf("%s %q passed validation with control characters", field, name)
+	}
+}
+
+func FuzzTransfer(f *testing.F) {
+	for _, seed := range []string{
+		`{"to_account":"9b2f6c1e-8a43-4c4e-b4d2-1f0e6c7a5d3b","amount":100}`,
+		`{"beneficiary_id":3,"amount":1,"category":"rent","metadata":{"invoice":"42"}}`,
+		`{"to_account":"x","beneficiary_id":3,"amount":1}`,
+		`{"to_account":"x","amount":0}`,
+		`{"to_account":"x","amount":-5}`,
+		`{"to_account":"x","amount":9223372036854775808}`,
+		`{"to_account":"x","amount":1.5}`,
+		`{"to_account":"x","amount":"NaN"}`,
+		`{"to_account":"x","amount":1,"category":"Rent!"}`,
+		`{"to_account":"x","amount":1,"metadata":{"":"empty key"}}`,
+		`{"beneficiary_id":-1,"amount":1}`,
+		`{}`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var req TransferRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return
+		}
+		if err := req.Validate(); err != nil {
+			return
+		}
+
+		if req.Amount <= 0 {
+			t.Errorf("amount %d passed validation", req.Amount)
+		}
+		if req.BeneficiaryID < 0 {
+			t.Errorf("beneficiary_id %d passed validation", req.BeneficiaryID)
+		}
+		if req.BeneficiaryID != 0 && req.ToAccount != "" {
+			t.Errorf("both to_account and beneficiary_id passed validation")
+		}
+		if req.Category != "" && !categoryPattern.MatchString(req.Category) {
+			t.Errorf("category %q passed validation", req.Category)
+		}
+		if len(req.Metadata) > maxMetadataKeys {
+			t.Errorf("%d metadata keys passed validation", len(req.Metadata))
+		}
+		for k, v := range req.Metadata {
+			if k == "" || len(k) > maxMetadataKeyLength || len(v) > maxMetadataValueLength {
+				t.Errorf("metadata %q: %q passed validation", k, v)
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// GeoLocation is where a client IP appears to be, as far as a GeoLocator
+// can tell. Any field may be empty.
+type GeoLocation struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
+// GeoLocator resolves client IPs for the login audit log. Operators plug
+// in their own lookup with ApiServer.SetGeoLocator; by default nothing is
+// looked up. Locate may return nil for addresses it knows nothing about.
+type GeoLocator interface {
+	Locate(ctx context.Context, ip string) (*GeoLocation, error)
+}
+
+type noGeoLocator struct{}
+
+func (noGeoLocator) Locate(context.Context, string) (*GeoLocation, error) {
+	return nil, nil
+}
+
+// Value stores the location as jsonb; a nil location is stored as null.
+func (g *GeoLocation) Value() (driver.Value, error) {
+	if g == nil {
+		return nil, nil
+	}
+	return json.Marshal(g)
+}
+
+func (g *GeoLocation) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*g = GeoLocation{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, g)
+	case string:
+		return json.Unmarshal([]byte(v), g)
+	}
+	return fmt.Errorf("cannot scan %T into GeoLocation", src)
+}
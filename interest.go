@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// InterestJob accrues daily interest on savings accounts. Each account is
+// credited at most once per day however often the job runs, so restarts
+// and crashes mid-run never pay interest twice.
+type InterestJob struct {
+	store    Storage
+	rate     float64
+	interval time.Duration
+}
+
+func NewInterestJob(store Storage, config *Config) *InterestJob {
+	return &InterestJob{
+		store:    store,
+		rate:     config.InterestRate,
+		interval: config.InterestInterval,
+	}
+}
+
+// Run accrues interest for the current day, then again every interval,
+// until ctx is cancelled.
+func (j *InterestJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.accrue(ctx, time.Now().UTC()); err != nil {
+			log.Println("interest accrual failed:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// accrue credits every savings account with one day of interest for the
+// day containing now, rounded down to whole balance units.
+func (j *InterestJob) accrue(ctx context.Context, now time.Time) error {
+	period := now.Format("2006-01-02")
+
+	accounts, err := j.store.GetAccountsByType(ctx, AccountTypeSavings)
+	if err != nil {
+		return err
+	}
+
+	for _, acc := range accounts {
+		interest := Money(float64(acc.Balance) * j.rate / 365)
+		if interest <= 0 {
+			continue
+		}
+		if _, err := j.store.PostInterest(ctx, acc.ID, period, interest); err != nil {
+			log.Printf("interest accrual for account %d failed: %v", acc.ID, err)
+		}
+	}
+	return nil
+}
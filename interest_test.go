@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInterestJobAccrue(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		balance Money
+		kind    string
+		want    Money
+	}{
+		{"savings", 0.0365, 1000000, AccountTypeSavings, 1000100},
+		{"rounded down", 0.05, 1000, AccountTypeSavings, 1000},
+		{"just over a unit", 0.0365, 10000, AccountTypeSavings, 10001},
+		{"checking earns nothing", 0.0365, 1000000, AccountTypeChecking, 1000000},
+		{"overdrawn earns nothing", 0.0365, -1000000, AccountTypeSavings, -1000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			acc, err := NewAccount(context.Background(), "Ada", "Lovelace", "correct horse battery staple", "savings-1", Pepper{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			acc.Type, acc.Balance = tt.kind, tt.balance
+			if err := store.CreateAccount(context.Background(), acc); err != nil {
+				t.Fatal(err)
+			}
+			job := NewInterestJob(store, &Config{InterestRate: tt.rate, InterestInterval: time.Hour})
+
+			// Several runs on one day credit the account once.
+			day := time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC)
+			for _, at := range []time.Time{day, day.Add(time.Hour), day.Add(22 * time.Hour)} {
+				if err := job.accrue(context.Background(), at); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := store.GetAccountByID(context.Background(), int(acc.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Balance != tt.want {
+				t.Errorf("balance after one day = %d, want %d", got.Balance, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterestJobAccruesEachDay(t *testing.T) {
+	store := NewMemoryStore()
+	acc, err := NewAccount(context.Background(), "Ada", "Lovelace", "correct horse battery staple", "savings-1", Pepper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Type, acc.Balance = AccountTypeSavings, 1000000
+	if err := store.CreateAccount(context.Background(), acc); err != nil {
+		t.Fatal(err)
+	}
+	job := NewInterestJob(store, &Config{InterestRate: 0.0365, InterestInterval: time.Hour})
+
+	day := time.Date(2026, 3, 4, 23, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{day, day.Add(2 * time.Hour), day.Add(3 * time.Hour)} {
+		if err := job.accrue(context.Background(), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := store.GetAccountByID(context.Background(), int(acc.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Day two compounds on day one's interest: 1000100 * 0.0001, rounded
+	// down.
+	if want := Money(1000100 + 100); got.Balance != want {
+		t.Errorf("balance after two days = %d, want %d", got.Balance, want)
+	}
+}
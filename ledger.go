@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	LedgerKindOpeningDeposit = "opening_deposit"
+	LedgerKindTransfer       = "transfer"
+	LedgerKindInterest       = "interest"
+)
+
+var ErrInsufficientFunds = newStatusError(http.StatusUnprocessableEntity, "insufficient funds")
+
+// LedgerEntry is one line of a double-entry posting. All lines sharing a
+// TransactionID sum to zero, and an account's balance is the sum of its
+// lines. A nil AccountID is the outside world, the counterpart of money
+// entering or leaving the bank, such as an opening deposit.
+type LedgerEntry struct {
+	ID              int64     `json:"id"`
+	TransactionID   string    `json:"transaction_id"`
+	AccountID       *int64    `json:"account_id"`
+	Amount          Money     `json:"amount"`
+	AmountFormatted string    `json:"amount_formatted,omitempty"`
+	Kind            string    `json:"kind"`
+	Category        string    `json:"category,omitempty"`
+	Metadata        Metadata  `json:"metadata,omitempty"`
+	CreatedAt       Timestamp `json:"created_at"`
+}
+
+// TransactionFilter narrows a transaction listing to lines created in the
+// half-open range [From, To) whose metadata contains every pair in
+// Metadata and that are tagged with Category. A nil Metadata or an empty
+// Category matches any line.
+type TransactionFilter struct {
+	From     time.Time
+	To       time.Time
+	Metadata Metadata
+	Category string
+}
+
+// CategoryTotal sums an account's ledger lines tagged with one category.
+// Lines without a category are totalled under the empty category.
+type CategoryTotal struct {
+	Category string `json:"category"`
+	Total    Money  `json:"total"`
+	Count    int    `json:"count"`
+}
+
+// FlowSummary totals the money that entered and left an account over
+// [From, To). Outflows are reported as a positive amount.
+type FlowSummary struct {
+	Period   string    `json:"period"`
+	From     Timestamp `json:"from"`
+	To       Timestamp `json:"to"`
+	Inflows  Money     `json:"inflows"`
+	Outflows Money     `json:"outflows"`
+	Net      Money     `json:"net"`
+}
+
+// Summary periods are calendar periods in UTC; weeks start on Monday.
+const (
+	SummaryPeriodDay   = "day"
+	SummaryPeriodWeek  = "week"
+	SummaryPeriodMonth = "month"
+	SummaryPeriodYear  = "year"
+)
+
+// periodStart returns when the period containing now began.
+func periodStart(period string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case SummaryPeriodDay:
+		return day, nil
+	case SummaryPeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	case SummaryPeriodMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case SummaryPeriodYear:
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period given %q: must be %q, %q, %q or %q",
+		period, SummaryPeriodDay, SummaryPeriodWeek, SummaryPeriodMonth, SummaryPeriodYear)
+}
+
+// categoryPattern is what a transfer's category must look like, such as
+// "rent" or "car-loan".
+var categoryPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func validateCategory(category string) error {
+	if category != "" && !categoryPattern.MatchString(category) {
+		return fmt.Errorf("invalid category given %q: must be 1 to 32 lowercase letters, digits, dashes or underscores", category)
+	}
+	return nil
+}
+
+// BalanceMismatch is an account whose stored balance has drifted from the
+// sum of its ledger lines.
+type BalanceMismatch struct {
+	AccountID     int64  `json:"account_id"`
+	Number        string `json:"number"`
+	StoredBalance Money  `json:"stored_balance"`
+	LedgerBalance Money  `json:"ledger_balance"`
+	Difference    Money  `json:"difference"`
+}
+
+// LedgerVerification is the outcome of replaying one account's ledger.
+// Repaired is set when the stored balance was overwritten with the ledger
+// balance; the other fields describe the account as it was found.
+type LedgerVerification struct {
+	BalanceMismatch
+	Entries  int  `json:"entries"`
+	Matches  bool `json:"matches"`
+	Repaired bool `json:"repaired"`
+}
+
+// validateEntries checks that the lines form a balanced posting.
+func validateEntries(entries []*LedgerEntry) error {
+	if len(entries) < 2 {
+		return fmt.Errorf("a posting needs at least two ledger lines, got %d", len(entries))
+	}
+
+	var sum Money
+	for _, entry := range entries {
+		if entry.Amount == 0 {
+			return fmt.Errorf("ledger lines must have a non-zero amount")
+		}
+		if entry.Kind == "" {
+			return fmt.Errorf("ledger lines must have a kind")
+		}
+		var err error
+		if sum, err = sum.Add(entry.Amount); err != nil {
+			return err
+		}
+	}
+	if sum != 0 {
+		return fmt.Errorf("ledger lines must sum to zero, got %d", sum)
+	}
+	return nil
+}
+
+// Limits on transfer metadata, so it stays a correlation aid and does not
+// turn into a document store.
+const (
+	maxMetadataKeys        = 20
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
+)
+
+// Metadata is caller supplied key/value data kept with a transfer's ledger
+// lines, such as an order id, for matching it up later. It is stored as
+// jsonb; a nil Metadata is stored as null.
+type Metadata map[string]string
+
+func (m Metadata) Validate() error {
+	if len(m) > maxMetadataKeys {
+		return fmt.Errorf("metadata may have at most %d keys, got %d", maxMetadataKeys, len(m))
+	}
+	for k, v := range m {
+		if k == "" || len(k) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata keys must be between 1 and %d bytes, got %q", maxMetadataKeyLength, k)
+		}
+		if len(v) > maxMetadataValueLength {
+			return fmt.Errorf("metadata value for %q must be at most %d bytes", k, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *Metadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return fmt.Errorf("cannot scan %T into Metadata", src)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// 2026-03-04 is a Wednesday.
+	wednesday := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		period  string
+		now     time.Time
+		want    time.Time
+		wantErr bool
+	}{
+		{period: SummaryPeriodDay, now: wednesday, want: time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodWeek, now: wednesday, want: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodWeek, now: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodWeek, now: time.Date(2026, 3, 8, 23, 59, 0, 0, time.UTC), want: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodWeek, now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), want: time.Date(2025, 12, 29, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodMonth, now: wednesday, want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{period: SummaryPeriodYear, now: wednesday, want: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// 01:00 on the 1st in Berlin is still the last day of the
+		// previous month in UTC.
+		{period: SummaryPeriodMonth, now: time.Date(2026, 4, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{period: "quarter", now: wednesday, wantErr: true},
+		{period: "", now: wednesday, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period+" "+tt.now.Format(time.RFC3339), func(t *testing.T) {
+			got, err := periodStart(tt.period, tt.now)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid period") {
+					t.Fatalf("got %v, %v, want an invalid period error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("periodStart = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleGetSummary(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 10000)
+	other := newTestAccount(t, store, 5000)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+	ctx := context.Background()
+	if _, err := store.Transfer(ctx, acc.Number, other.Number, 2500, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Transfer(ctx, other.Number, acc.Number, 700, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	token := testToken(t, acc)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantPeriod string
+	}{
+		{"", http.StatusOK, SummaryPeriodMonth},
+		{"?period=day", http.StatusOK, SummaryPeriodDay},
+		{"?period=year", http.StatusOK, SummaryPeriodYear},
+		{"?period=quarter", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := serveTest(server, http.MethodGet, fmt.Sprintf("/accounts/%d/summary%s", acc.ID, tt.query), token, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var summary FlowSummary
+			if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+				t.Fatal(err)
+			}
+			// The opening deposit counts as an inflow too.
+			if summary.Period != tt.wantPeriod || summary.Inflows != 10700 || summary.Outflows != 2500 || summary.Net != 8200 {
+				t.Errorf("got %+v", summary)
+			}
+		})
+	}
+}
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	store, err := NewPostgresStore()
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	setBcryptConcurrency(config.BcryptConcurrency)
+	setMoneyAsString(config.MoneyAsString)
+	setJWTSecret(config.JWTSecret)
+
+	store, err := NewPostgresStore(config)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -13,6 +26,27 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s := NewApiServer(":3000", store)
-	s.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if config.WebhookURL != "" {
+		go NewWebhookDispatcher(store, config).Run(ctx)
+	}
+
+	if config.InterestRate > 0 {
+		go NewInterestJob(store, config).Run(ctx)
+	}
+
+	if config.AccountDeletionGrace > 0 {
+		go NewPurgeJob(store, config).Run(ctx)
+	}
+
+	if config.SnapshotInterval > 0 {
+		go NewSnapshotJob(store, config).Run(ctx)
+	}
+
+	s := NewApiServer(":3000", store, config)
+	if err := s.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Storage for tests and benchmarks that need
+// no database. It only implements what they exercise; any other method
+// falls through to the nil embedded Storage and panics, which points
+// straight at what is missing.
+type MemoryStore struct {
+	Storage
+
+	mu       sync.Mutex
+	nextID   int64
+	accounts map[string]*Account
+	apiKeys  map[string]*APIKey
+	epoch    time.Time
+	// ledger holds the account side of each posting, oldest first.
+	ledger []*LedgerEntry
+	// accruals holds the interest periods already posted, per account.
+	accruals map[int64]map[string]bool
+	events   []*AuditEvent
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts: map[string]*Account{},
+		apiKeys:  map[string]*APIKey{},
+		accruals: map[int64]map[string]bool{},
+	}
+}
+
+func (s *MemoryStore) CreateAccount(ctx context.Context, acc *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[acc.Number]; ok {
+		return fmt.Errorf("account number %s is taken", acc.Number)
+	}
+	s.nextID++
+	acc.ID = s.nextID
+	stored := *acc
+	s.accounts[acc.Number] = &stored
+	if acc.Balance != 0 {
+		s.post(acc.ID, "", acc.Balance, LedgerKindOpeningDeposit, acc.CreatedAt.Time)
+	}
+	return nil
+}
+
+// post records one ledger line for accountID.
+func (s *MemoryStore) post(accountID int64, transactionID string, amount Money, kind string, at time.Time) *LedgerEntry {
+	s.nextID++
+	id := accountID
+	entry := &LedgerEntry{
+		ID:            s.nextID,
+		TransactionID: transactionID,
+		AccountID:     &id,
+		Amount:        amount,
+		Kind:          kind,
+		CreatedAt:     NewTimestamp(at),
+	}
+	s.ledger = append(s.ledger, entry)
+	return entry
+}
+
+func (s *MemoryStore) GetAccountByNumber(ctx context.Context, number string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[number]
+	if !ok {
+		return nil, fmt.Errorf("account %s %w", number, ErrAccountNotFound)
+	}
+	found := *acc
+	return &found, nil
+}
+
+func (s *MemoryStore) AccountExists(ctx context.Context, number string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.accounts[number]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetAccountByID(ctx context.Context, id int) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, acc := range s.accounts {
+		if acc.ID == int64(id) {
+			found := *acc
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+}
+
+func (s *MemoryStore) GetTokenEpoch(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epoch, nil
+}
+
+func (s *MemoryStore) BumpTokenEpoch(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epoch = time.Now().UTC()
+	return s.epoch, nil
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	k.ID = s.nextID
+	stored := *k
+	s.apiKeys[k.KeyHash] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[hash]
+	if !ok || k.RevokedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	found := *k
+	return &found, nil
+}
+
+// Transfer follows PostgresStore.Transfer, minus the ledger: only active
+// accounts send or receive, and nil is returned for an unknown
+// destination.
+func (s *MemoryStore) Transfer(ctx context.Context, fromNumber, toNumber string, amount Money, category string, metadata Metadata) (*TransferResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, ok := s.accounts[fromNumber]
+	if !ok || from.Status != AccountStatusActive {
+		return nil, fmt.Errorf("account %s %w", fromNumber, ErrAccountNotFound)
+	}
+	to, ok := s.accounts[toNumber]
+	if !ok || to.Status != AccountStatusActive {
+		return nil, nil
+	}
+
+	debited, err := from.Balance.Add(-amount)
+	if err != nil {
+		return nil, err
+	}
+	if debited < 0 {
+		return nil, ErrInsufficientFunds
+	}
+	credited, err := to.Balance.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+	from.Balance, to.Balance = debited, credited
+
+	transactionID, now := uuid.NewString(), time.Now()
+	for _, entry := range []*LedgerEntry{
+		s.post(from.ID, transactionID, -amount, LedgerKindTransfer, now),
+		s.post(to.ID, transactionID, amount, LedgerKindTransfer, now),
+	} {
+		entry.Category, entry.Metadata = category, metadata
+	}
+
+	return &TransferResult{
+		TransactionID: transactionID,
+		From:          fromNumber,
+		To:            toNumber,
+		Amount:        amount,
+		Category:      category,
+		Balance:       debited,
+		CreatedAt:     NewTimestamp(time.Now()),
+	}, nil
+}
+
+// AnonymizeAccounts follows PostgresStore.AnonymizeAccounts, dropping the
+// accounts it closes since nothing reads an anonymized account back.
+func (s *MemoryStore) AnonymizeAccounts(ctx context.Context, ids []int) ([]*DeleteResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byID := map[int]*Account{}
+	for _, acc := range s.accounts {
+		byID[int(acc.ID)] = acc
+	}
+
+	results := make([]*DeleteResult, 0, len(ids))
+	funded := false
+	for _, id := range ids {
+		acc, ok := byID[id]
+		switch {
+		case !ok:
+			results = append(results, &DeleteResult{ID: id, Result: DeleteResultNotFound})
+		case acc.Balance != 0:
+			results = append(results, &DeleteResult{ID: id, Result: DeleteResultHasFunds})
+			funded = true
+		default:
+			results = append(results, &DeleteResult{ID: id, Result: DeleteResultDeleted})
+		}
+	}
+	if funded {
+		for _, result := range results {
+			if result.Result == DeleteResultDeleted {
+				result.Result = DeleteResultRolledBack
+			}
+		}
+		return results, ErrAccountHasFunds
+	}
+	for _, result := range results {
+		if result.Result == DeleteResultDeleted {
+			delete(s.accounts, byID[result.ID].Number)
+		}
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) GetTransactions(ctx context.Context, accountID int64, filter TransactionFilter, limit, offset int) ([]*LedgerEntry, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []*LedgerEntry
+	for i := len(s.ledger) - 1; i >= 0; i-- {
+		entry := s.ledger[i]
+		at := entry.CreatedAt.Time
+		if *entry.AccountID != accountID || at.Before(filter.From) || !at.Before(filter.To) {
+			continue
+		}
+		if filter.Category != "" && entry.Category != filter.Category {
+			continue
+		}
+		matches := true
+		for k, v := range filter.Metadata {
+			matches = matches && entry.Metadata[k] == v
+		}
+		if matches {
+			found := *entry
+			matched = append(matched, &found)
+		}
+	}
+
+	total := len(matched)
+	if offset > total {
+		offset = total
+	}
+	matched = matched[offset:]
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched, total, nil
+}
+
+func (s *MemoryStore) GetBalanceAsOf(ctx context.Context, accountID int64, before time.Time) (Money, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var balance Money
+	for _, entry := range s.ledger {
+		if *entry.AccountID == accountID && entry.CreatedAt.Time.Before(before) {
+			balance += entry.Amount
+		}
+	}
+	return balance, nil
+}
+
+func (s *MemoryStore) GetFlowTotals(ctx context.Context, accountID int64, from, to time.Time) (inflows, outflows Money, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.ledger {
+		at := entry.CreatedAt.Time
+		if *entry.AccountID != accountID || at.Before(from) || !at.Before(to) {
+			continue
+		}
+		if entry.Amount > 0 {
+			inflows += entry.Amount
+		} else {
+			outflows -= entry.Amount
+		}
+	}
+	return inflows, outflows, nil
+}
+
+func (s *MemoryStore) GetAccountsByType(ctx context.Context, accountType string) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*Account{}
+	for _, acc := range s.accounts {
+		if acc.Type == accountType {
+			found := *acc
+			accounts = append(accounts, &found)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+// PostInterest follows PostgresStore.PostInterest: a second posting for
+// the same account and period is ignored and reports false.
+func (s *MemoryStore) PostInterest(ctx context.Context, accountID int64, period string, amount Money) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var acc *Account
+	for _, candidate := range s.accounts {
+		if candidate.ID == accountID {
+			acc = candidate
+		}
+	}
+	if acc == nil {
+		return false, fmt.Errorf("account %d %w", accountID, ErrAccountNotFound)
+	}
+	if s.accruals[accountID][period] {
+		return false, nil
+	}
+	balance, err := acc.Balance.Add(amount)
+	if err != nil {
+		return false, err
+	}
+	if s.accruals[accountID] == nil {
+		s.accruals[accountID] = map[string]bool{}
+	}
+	s.accruals[accountID][period] = true
+	acc.Balance = balance
+	s.post(accountID, "", amount, LedgerKindInterest, time.Now())
+	return true, nil
+}
+
+func (s *MemoryStore) CreateAuditEvent(ctx context.Context, event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	event.ID = s.nextID
+	stored := *event
+	s.events = append(s.events, &stored)
+	return nil
+}
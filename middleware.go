@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE"
+	corsAllowedHeaders = "Content-Type, x-jwt-token, x-api-key, Idempotency-Key, X-Response-Shape"
+)
+
+// withCORS adds CORS headers for the configured origins and answers
+// preflight requests itself, since the router registers no OPTIONS routes.
+func withCORS(next http.Handler, config *Config) http.Handler {
+	if len(config.CORSAllowedOrigins) == 0 {
+		return next
+	}
+
+	allowed := map[string]bool{}
+	for _, origin := range config.CORSAllowedOrigins {
+		allowed[origin] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if allowed["*"] {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if config.CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if config.CORSMaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(config.CORSMaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout answers 503 for requests still running after timeout, so a
+// slow handler cannot hold a client open indefinitely. The handler's
+// context is cancelled at the deadline, which also aborts its queries.
+// Streaming requests are passed through untouched, since
+// http.TimeoutHandler buffers the response and does not support hijacking.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// TimeoutHandler derives its deadline from this one, so by the time
+		// it gives up ctx is done too.
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		tw := &timeoutResponseWriter{ResponseWriter: w, r: r}
+		http.TimeoutHandler(next, timeout, "").ServeHTTP(tw, r)
+	})
+}
+
+// timeoutResponseWriter replaces http.TimeoutHandler's fixed 503 body
+// with errRequestTimeout written through WriteError, so a timed out
+// request gets the same error format, Content-Type included, as any other
+// failure. A handler's own 503 passes through as long as it came in
+// before the deadline.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	timedOut bool
+}
+
+func (w *timeoutResponseWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.r.Context().Err() != nil {
+		w.timedOut = true
+		WriteError(w.ResponseWriter, w.r, errRequestTimeout)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutResponseWriter) Write(b []byte) (int, error) {
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// withConcurrencyLimit turns requests away with a 503 once limit of them
+// are being handled, rather than letting them queue up on the database
+// pool. It sits inside withTimeout so a slot stays taken until the handler
+// has actually returned, even after its client was answered. Streaming
+// requests are left out of the count, since they are long-lived by design.
+func withConcurrencyLimit(next http.Handler, limit int) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "1")
+			WriteError(w, r, errServerBusy)
+			return
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withHead answers HEAD requests by running the GET handler for the same
+// URL and dropping its body, so every GET route supports HEAD without
+// registering it twice. The headers, Content-Length included, are the ones
+// the GET would have sent.
+func withHead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+		hw := &headResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, get)
+		hw.finish()
+	})
+}
+
+// headResponseWriter counts the body instead of writing it, and holds the
+// status back until the handler is done so a Content-Length can still be
+// set from the count.
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.length += len(b)
+	return len(b), nil
+}
+
+func (w *headResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	if w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// isStreamingRequest reports whether r asks for a WebSocket upgrade or a
+// server-sent event stream.
+func isStreamingRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// withAllowedHosts rejects requests whose Host header is not one of the
+// configured hosts, so a spoofed Host cannot leak into the links the API
+// builds. Entries without a port match the host on any port.
+func withAllowedHosts(next http.Handler, hosts []string) http.Handler {
+	if len(hosts) == 0 {
+		return next
+	}
+
+	allowed := map[string]bool{}
+	for _, host := range hosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		if !allowed[host] && !allowed[hostname] {
+			WriteError(w, r, statusErrorf(http.StatusBadRequest, "unexpected host %q", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
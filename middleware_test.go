@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	busy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, errServerBusy)
+	})
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		accept     string
+		wantStatus int
+		wantType   string
+		wantInBody string
+	}{
+		{"timed out", slow, "", http.StatusServiceUnavailable, "application/json", `"error":"request timed out"`},
+		{"timed out as problem", slow, problemContentType, http.StatusServiceUnavailable, problemContentType, "urn:gobank:problem:request-timeout"},
+		{"handler's own 503", busy, "", http.StatusServiceUnavailable, "application/json", "server busy"},
+		{"in time", fast, "", http.StatusOK, "application/json", `"status":"ok"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/account", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			withTimeout(tt.handler, 20*time.Millisecond).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantType) {
+				t.Fatalf("Content-Type = %q, want %q", ct, tt.wantType)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.wantInBody) {
+				t.Fatalf("body = %q, want it to contain %q", body, tt.wantInBody)
+			}
+		})
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	listed := &Config{CORSAllowedOrigins: []string{"https://app.example"}, CORSAllowCredentials: true, CORSMaxAge: 600}
+	wildcard := &Config{CORSAllowedOrigins: []string{"*"}}
+
+	tests := []struct {
+		name            string
+		config          *Config
+		method          string
+		origin          string
+		preflight       bool
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+		wantMaxAge      string
+	}{
+		{name: "disabled", config: &Config{}, method: http.MethodGet, origin: "https://app.example", wantStatus: http.StatusTeapot},
+		{name: "listed origin", config: listed, method: http.MethodGet, origin: "https://app.example", wantStatus: http.StatusTeapot, wantOrigin: "https://app.example", wantCredentials: "true"},
+		{name: "other origin", config: listed, method: http.MethodGet, origin: "https://evil.example", wantStatus: http.StatusTeapot},
+		{name: "no origin", config: listed, method: http.MethodGet, wantStatus: http.StatusTeapot},
+		{name: "preflight", config: listed, method: http.MethodOptions, origin: "https://app.example", preflight: true, wantStatus: http.StatusNoContent, wantOrigin: "https://app.example", wantCredentials: "true", wantMaxAge: "600"},
+		{name: "preflight from other origin", config: listed, method: http.MethodOptions, origin: "https://evil.example", preflight: true, wantStatus: http.StatusTeapot},
+		{name: "options without request method", config: listed, method: http.MethodOptions, origin: "https://app.example", wantStatus: http.StatusTeapot, wantOrigin: "https://app.example", wantCredentials: "true"},
+		{name: "wildcard", config: wildcard, method: http.MethodGet, origin: "https://any.example", wantStatus: http.StatusTeapot, wantOrigin: "*"},
+		{name: "wildcard preflight", config: wildcard, method: http.MethodOptions, origin: "https://any.example", preflight: true, wantStatus: http.StatusNoContent, wantOrigin: "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/accounts", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			withCORS(ok, tt.config).ServeHTTP(w, r)
+
+			h := w.Header()
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if preflight := w.Code == http.StatusNoContent; preflight && h.Get("Access-Control-Allow-Methods") != corsAllowedMethods {
+				t.Errorf("Allow-Methods = %q", h.Get("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
+
+func TestWithHead(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		wantStatus int
+		wantLength string
+		wantBody   string
+	}{
+		{
+			name: "json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				WriteJSON(w, http.StatusOK, map[string]string{"method": r.Method})
+			},
+			method:     http.MethodHead,
+			wantStatus: http.StatusOK,
+			wantLength: "17",
+		},
+		{
+			name: "raw body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello, "))
+				w.Write([]byte("world"))
+			},
+			method:     http.MethodHead,
+			wantStatus: http.StatusOK,
+			wantLength: "12",
+		},
+		{
+			name:       "error status",
+			handler:    func(w http.ResponseWriter, r *http.Request) { WriteError(w, r, ErrAccountNotFound) },
+			method:     http.MethodHead,
+			wantStatus: http.StatusNotFound,
+			wantLength: strconv.Itoa(len(`{"error":""}`+"\n") + len(ErrAccountNotFound.Error())),
+		},
+		{
+			name:       "no body",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			method:     http.MethodHead,
+			wantStatus: http.StatusNoContent,
+			wantLength: "0",
+		},
+		{
+			name:       "get passes through",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Method)) },
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   "GET",
+		},
+		{
+			name:       "post passes through",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Method)) },
+			method:     http.MethodPost,
+			wantStatus: http.StatusOK,
+			wantBody:   "POST",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			withHead(tt.handler).ServeHTTP(w, httptest.NewRequest(tt.method, "/accounts/1", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 1234)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+	token := testToken(t, acc)
+	path := fmt.Sprintf("/accounts/%d", acc.ID)
+
+	get := serveTest(server, http.MethodGet, path, token, "")
+	head := serveTest(server, http.MethodHead, path, token, "")
+	if get.Code != http.StatusOK || head.Code != http.StatusOK {
+		t.Fatalf("GET %d, HEAD %d, want 200 for both", get.Code, head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD sent a %d byte body", head.Body.Len())
+	}
+	for _, header := range []string{"Content-Type", "Content-Length", "ETag"} {
+		if g, h := get.Header().Get(header), head.Header().Get(header); g != h || g == "" {
+			t.Errorf("%s: GET %q, HEAD %q", header, g, h)
+		}
+	}
+}
+
+func TestWithAllowedHosts(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	tests := []struct {
+		name       string
+		hosts      []string
+		host       string
+		wantStatus int
+	}{
+		{"no allowlist", nil, "anything.example", http.StatusNoContent},
+		{"listed", []string{"api.example"}, "api.example", http.StatusNoContent},
+		{"case insensitive", []string{"API.example"}, "api.EXAMPLE", http.StatusNoContent},
+		{"any port without one listed", []string{"api.example"}, "api.example:8443", http.StatusNoContent},
+		{"listed port", []string{"localhost:3000"}, "localhost:3000", http.StatusNoContent},
+		{"other port than listed", []string{"localhost:3000"}, "localhost:4000", http.StatusBadRequest},
+		{"unlisted", []string{"api.example"}, "evil.example", http.StatusBadRequest},
+		{"suffix is not enough", []string{"api.example"}, "api.example.evil", http.StatusBadRequest},
+		{"ipv6", []string{"::1"}, "[::1]:3000", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			withAllowedHosts(ok, tt.hosts).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(w.Body.String(), "unexpected host") {
+				t.Errorf("body = %s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units of the bank's currency, the integer
+// the balance column stores; see Currency for what one unit is worth.
+// Sums of amounts go through Add and Sub, which refuse to wrap around.
+type Money int64
+
+// ErrAmountOverflow is returned when a sum of amounts does not fit in
+// Money.
+var ErrAmountOverflow = newStatusError(http.StatusUnprocessableEntity, "amount out of range")
+
+// Add returns m + n, or ErrAmountOverflow when that overflows.
+func (m Money) Add(n Money) (Money, error) {
+	sum := m + n
+	if (n > 0 && sum < m) || (n < 0 && sum > m) {
+		return 0, ErrAmountOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns m - n, or ErrAmountOverflow when that overflows.
+func (m Money) Sub(n Money) (Money, error) {
+	diff := m - n
+	if (n > 0 && diff > m) || (n < 0 && diff < m) {
+		return 0, ErrAmountOverflow
+	}
+	return diff, nil
+}
+
+// moneyAsString makes amounts marshal as JSON strings, for clients whose
+// parsers read every number as a float64 and would lose precision on large
+// amounts. It is set once at startup.
+var moneyAsString bool
+
+func setMoneyAsString(on bool) {
+	moneyAsString = on
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	n := strconv.FormatInt(int64(m), 10)
+	if moneyAsString {
+		return []byte(`"` + n + `"`), nil
+	}
+	return []byte(n), nil
+}
+
+// UnmarshalJSON accepts amounts both as numbers and as strings of digits,
+// whichever way the server writes them. A string has to be quoted at both
+// ends; "12 or 12" is rejected rather than read as 12.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) >= 2 && data[0] == '"' && data[len(data)-1] == '"' {
+		data = data[1 : len(data)-1]
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("amount must be a whole number of minor units, got %s", data)
+	}
+	*m = Money(n)
+	return nil
+}
+
+// moneyLocale holds the separators a locale uses to display amounts.
+type moneyLocale struct {
+	group   string
+	decimal string
+}
+
+// moneyLocales is keyed by lowercase language tag. Lookups try the full tag
+// before its base language, so "de-CH" overrides "de" but "de-AT" falls
+// back to it.
+var moneyLocales = map[string]moneyLocale{
+	"en":    {group: ",", decimal: "."},
+	"de":    {group: ".", decimal: ","},
+	"de-ch": {group: "’", decimal: "."},
+	"es":    {group: ".", decimal: ","},
+	"fr":    {group: " ", decimal: ","},
+	"it":    {group: ".", decimal: ","},
+	"ja":    {group: ",", decimal: "."},
+	"nl":    {group: ".", decimal: ","},
+	"pt":    {group: ".", decimal: ","},
+	"pt-br": {group: ".", decimal: ","},
+}
+
+// requestLocale picks the client's preferred supported locale from the
+// Accept-Language header. It returns false when the header is missing or
+// names nothing supported, in which case amounts are left unformatted.
+func requestLocale(r *http.Request) (moneyLocale, bool) {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			tags = append(tags, tag{strings.ToLower(name), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if loc, ok := moneyLocales[t.name]; ok {
+			return loc, true
+		}
+		base, _, _ := strings.Cut(t.name, "-")
+		if loc, ok := moneyLocales[base]; ok {
+			return loc, true
+		}
+	}
+	return moneyLocale{}, false
+}
+
+// localizeAccounts fills in the formatted balance of each account when the
+// request asked for a supported locale.
+func (s *ApiServer) localizeAccounts(r *http.Request, accounts ...*Account) {
+	loc, ok := requestLocale(r)
+	if !ok {
+		return
+	}
+	for _, acc := range accounts {
+		acc.BalanceFormatted = s.currency.Format(acc.Balance, loc)
+	}
+}
+
+// localizeEntries is localizeAccounts for ledger lines.
+func (s *ApiServer) localizeEntries(r *http.Request, entries ...*LedgerEntry) {
+	loc, ok := requestLocale(r)
+	if !ok {
+		return
+	}
+	for _, entry := range entries {
+		entry.AmountFormatted = s.currency.Format(entry.Amount, loc)
+	}
+}
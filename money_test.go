@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: `1250`, want: 1250},
+		{in: `"1250"`, want: 1250},
+		{in: `-40`, want: -40},
+		{in: `"-40"`, want: -40},
+		{in: `"9223372036854775807"`, want: 9223372036854775807},
+		{in: `null`, want: 7},
+		{in: `"1250`, wantErr: true},
+		{in: `1250"`, wantErr: true},
+		{in: `""1250""`, wantErr: true},
+		{in: `"`, wantErr: true},
+		{in: `""`, wantErr: true},
+		{in: `"null"`, wantErr: true},
+		{in: `12.50`, wantErr: true},
+		{in: `"9223372036854775808"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			m := Money(7)
+			err := m.UnmarshalJSON([]byte(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d, want an error", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != tt.want {
+				t.Errorf("got %d, want %d", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	const max, min = Money(math.MaxInt64), Money(math.MinInt64)
+	tests := []struct {
+		name    string
+		m, n    Money
+		wantAdd Money
+		addErr  bool
+		wantSub Money
+		subErr  bool
+	}{
+		{name: "small", m: 150, n: 50, wantAdd: 200, wantSub: 100},
+		{name: "negative", m: -150, n: 50, wantAdd: -100, wantSub: -200},
+		{name: "zero", m: max, n: 0, wantAdd: max, wantSub: max},
+		{name: "max plus one", m: max, n: 1, addErr: true, wantSub: max - 1},
+		{name: "min minus one", m: min, n: 1, wantAdd: min + 1, subErr: true},
+		{name: "max minus min", m: max, n: min, wantAdd: -1, subErr: true},
+		{name: "min plus min", m: min, n: min, addErr: true, wantSub: 0},
+		{name: "zero minus min", m: 0, n: min, wantAdd: min, subErr: true},
+		{name: "just fits", m: max - 10, n: 10, wantAdd: max, wantSub: max - 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Add(tt.n)
+			switch {
+			case tt.addErr && !errors.Is(err, ErrAmountOverflow):
+				t.Errorf("%d + %d: got %d, %v, want ErrAmountOverflow", tt.m, tt.n, got, err)
+			case !tt.addErr && (err != nil || got != tt.wantAdd):
+				t.Errorf("%d + %d: got %d, %v, want %d", tt.m, tt.n, got, err, tt.wantAdd)
+			}
+
+			got, err = tt.m.Sub(tt.n)
+			switch {
+			case tt.subErr && !errors.Is(err, ErrAmountOverflow):
+				t.Errorf("%d - %d: got %d, %v, want ErrAmountOverflow", tt.m, tt.n, got, err)
+			case !tt.subErr && (err != nil || got != tt.wantSub):
+				t.Errorf("%d - %d: got %d, %v, want %d", tt.m, tt.n, got, err, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	tests := []struct {
+		header string
+		want   moneyLocale
+		wantOK bool
+	}{
+		{"", moneyLocale{}, false},
+		{"en-US", moneyLocales["en"], true},
+		{"de", moneyLocales["de"], true},
+		{"de-CH", moneyLocales["de-ch"], true},
+		{"de-AT", moneyLocales["de"], true},
+		{"DE-ch", moneyLocales["de-ch"], true},
+		{"xx, fr;q=0.5", moneyLocales["fr"], true},
+		{"en;q=0.3, de;q=0.9", moneyLocales["de"], true},
+		{"de;q=0, en;q=0.1", moneyLocales["en"], true},
+		{"de;q=oops, fr", moneyLocales["fr"], true},
+		{"xx, yy-ZZ", moneyLocale{}, false},
+		{"*", moneyLocale{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Language", tt.header)
+			got, ok := requestLocale(r)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("requestLocale(%q) = %+v, %v, want %+v, %v", tt.header, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetAccountFormatsBalance(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 123456)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+	token := testToken(t, acc)
+
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"", ""},
+		{"de-DE", "1.234,56"},
+		{"en-GB;q=0.8, fr;q=0.9", "1" + moneyLocales["fr"].group + "234,56"},
+		{"xx", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/accounts/%d", acc.ID), nil)
+			req.Header.Set("x-jwt-token", token)
+			req.Header.Set("Accept-Language", tt.language)
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got Account
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.BalanceFormatted != tt.want {
+				t.Errorf("balance_formatted = %q, want %q", got.BalanceFormatted, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AccountNumberFormatUUID     = "uuid"
+	AccountNumberFormatNumeric  = "numeric"
+	AccountNumberFormatSequence = "sequence"
+)
+
+// defaultAccountNumberAttempts is how many numbers are generated before
+// giving up when every candidate collides with an existing account, unless
+// ACCOUNT_NUMBER_ATTEMPTS says otherwise.
+const defaultAccountNumberAttempts = 5
+
+// NumberGenerator issues account numbers in one format and checks that a
+// number is well-formed for it.
+type NumberGenerator interface {
+	Generate(ctx context.Context) (string, error)
+	Validate(number string) error
+}
+
+func newNumberGenerator(format, prefix string, store Storage) NumberGenerator {
+	var g NumberGenerator
+	switch format {
+	case AccountNumberFormatNumeric:
+		g = numericGenerator{}
+	case AccountNumberFormatSequence:
+		g = sequenceGenerator{store: store}
+	default:
+		g = uuidGenerator{}
+	}
+	if prefix != "" {
+		g = prefixedGenerator{prefix: prefix, next: g}
+	}
+	return g
+}
+
+// accountNumberPrefixPattern is what ACCOUNT_NUMBER_PREFIX must look like:
+// a short lowercase tag ending in an underscore, such as "sbx_".
+var accountNumberPrefixPattern = regexp.MustCompile(`^[a-z0-9]{1,8}_$`)
+
+// prefixedGenerator marks the numbers of another generator with the
+// environment's prefix, so a sandbox number pasted into production is
+// caught instead of reaching some other account.
+type prefixedGenerator struct {
+	prefix string
+	next   NumberGenerator
+}
+
+func (g prefixedGenerator) Generate(ctx context.Context) (string, error) {
+	number, err := g.next.Generate(ctx)
+	if err != nil {
+		return "", err
+	}
+	return g.prefix + number, nil
+}
+
+func (g prefixedGenerator) Validate(number string) error {
+	rest, ok := strings.CutPrefix(number, g.prefix)
+	if !ok {
+		return fmt.Errorf("invalid account number %s: must start with %s", number, g.prefix)
+	}
+	return g.next.Validate(rest)
+}
+
+type uuidGenerator struct{}
+
+func (uuidGenerator) Generate(context.Context) (string, error) {
+	return uuid.NewString(), nil
+}
+
+func (uuidGenerator) Validate(number string) error {
+	if _, err := uuid.Parse(number); err != nil {
+		return fmt.Errorf("invalid account number %s", number)
+	}
+	return nil
+}
+
+// checkDestinationNumber catches mistyped numeric account numbers before
+// they reach the database. UUID numbers carry no checksum, so they always
+// pass, which also keeps accounts opened before a format switch reachable.
+// With a prefix configured, numbers carrying another environment's prefix
+// are refused, while unprefixed ones, from before the prefix was set, are
+// checked as they are.
+func checkDestinationNumber(format, prefix, number string) error {
+	if prefix != "" {
+		if rest, ok := strings.CutPrefix(number, prefix); ok {
+			number = rest
+		} else if tag, _, found := strings.Cut(number, "_"); found && accountNumberPrefixPattern.MatchString(tag+"_") {
+			return fmt.Errorf("account number %s is from another environment: numbers here start with %s", number, prefix)
+		}
+	}
+	if format != AccountNumberFormatNumeric && format != AccountNumberFormatSequence {
+		return nil
+	}
+	if _, err := uuid.Parse(number); err == nil {
+		return nil
+	}
+	return numericGenerator{}.Validate(number)
+}
+
+// numericLength is the number of digits in a numeric account number, the
+// last of which is a Luhn check digit.
+const numericLength = 10
+
+type numericGenerator struct{}
+
+func (numericGenerator) Generate(context.Context) (string, error) {
+	max := big.NewInt(numericPayloadCount)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return formatNumeric(n.Int64()), nil
+}
+
+// numericPayloadCount is how many distinct numeric account numbers exist.
+const numericPayloadCount = 9e8
+
+// formatNumeric turns n, below numericPayloadCount, into a numeric account
+// number. The leading digit is never zero so numbers keep their length when
+// handled as integers by spreadsheets and the like.
+func formatNumeric(n int64) string {
+	payload := fmt.Sprintf("%09d", n+1e8)
+	return payload + string(luhnCheckDigit(payload))
+}
+
+func (numericGenerator) Validate(number string) error {
+	if len(number) != numericLength {
+		return fmt.Errorf("invalid account number %s: must be %d digits", number, numericLength)
+	}
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("invalid account number %s: must be %d digits", number, numericLength)
+		}
+	}
+	payload := number[:numericLength-1]
+	if luhnCheckDigit(payload) != number[numericLength-1] {
+		return fmt.Errorf("invalid account number %s: checksum mismatch", number)
+	}
+	return nil
+}
+
+// sequenceGenerator issues numeric account numbers in order from a
+// database sequence, so two accounts never draw the same number and no
+// retries are needed. The numbers look like numericGenerator's and
+// validate the same way.
+type sequenceGenerator struct {
+	store Storage
+}
+
+func (g sequenceGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.store.NextAccountNumber(ctx)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || n >= numericPayloadCount {
+		return "", fmt.Errorf("account number sequence exhausted")
+	}
+	return formatNumeric(n), nil
+}
+
+func (sequenceGenerator) Validate(number string) error {
+	return numericGenerator{}.Validate(number)
+}
+
+// luhnCheckDigit returns the digit that makes payload+digit pass the Luhn
+// check. payload must contain only ASCII digits.
+func luhnCheckDigit(payload string) byte {
+	sum := 0
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		d := int(payload[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    byte
+	}{
+		{"7992739871", '3'},
+		{"0", '0'},
+		{"1", '8'},
+		{"100000000", '8'},
+		{"999999999", '9'},
+		{"123456789", '7'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			if got := luhnCheckDigit(tt.payload); got != tt.want {
+				t.Errorf("luhnCheckDigit(%q) = %c, want %c", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumericValidate(t *testing.T) {
+	tests := []struct {
+		number  string
+		wantErr string
+	}{
+		{number: "1000000008"},
+		{number: "1234567897"},
+		{number: "9999999999"},
+		{number: "1234567890", wantErr: "checksum mismatch"},
+		{number: "1234567879", wantErr: "checksum mismatch"},
+		{number: "123456789", wantErr: "must be 10 digits"},
+		{number: "12345678970", wantErr: "must be 10 digits"},
+		{number: "12345a7897", wantErr: "must be 10 digits"},
+		{number: "１２３４５６７８９７", wantErr: "must be 10 digits"},
+		{number: "", wantErr: "must be 10 digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			err := numericGenerator{}.Validate(tt.number)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatNumeric(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "1000000008"},
+		{23456789, "1234567897"},
+		{numericPayloadCount - 1, "9999999999"},
+	}
+	for _, tt := range tests {
+		got := formatNumeric(tt.n)
+		if got != tt.want {
+			t.Errorf("formatNumeric(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+		if err := (numericGenerator{}).Validate(got); err != nil {
+			t.Errorf("formatNumeric(%d) does not validate: %v", tt.n, err)
+		}
+	}
+}
+
+func TestNumericGenerateValidates(t *testing.T) {
+	g := numericGenerator{}
+	for i := 0; i < 100; i++ {
+		number, err := g.Generate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Validate(number); err != nil {
+			t.Fatalf("generated %s does not validate: %v", number, err)
+		}
+	}
+}
+
+func TestCheckDestinationNumber(t *testing.T) {
+	const uuidNumber = "3b241101-e2bb-4255-8caf-4136c566a962"
+	tests := []struct {
+		name    string
+		format  string
+		prefix  string
+		number  string
+		wantErr string
+	}{
+		{name: "uuid format skips the checksum", format: AccountNumberFormatUUID, number: "1234567890"},
+		{name: "numeric", format: AccountNumberFormatNumeric, number: "1234567897"},
+		{name: "numeric typo", format: AccountNumberFormatNumeric, number: "1234567987", wantErr: "checksum mismatch"},
+		{name: "sequence typo", format: AccountNumberFormatSequence, number: "1234567890", wantErr: "checksum mismatch"},
+		{name: "uuid from before a format switch", format: AccountNumberFormatNumeric, number: uuidNumber},
+		{name: "own prefix", format: AccountNumberFormatNumeric, prefix: "sbx_", number: "sbx_1234567897"},
+		{name: "own prefix with typo", format: AccountNumberFormatNumeric, prefix: "sbx_", number: "sbx_1234567890", wantErr: "checksum mismatch"},
+		{name: "unprefixed from before the prefix", format: AccountNumberFormatNumeric, prefix: "sbx_", number: "1234567897"},
+		{name: "other environment", format: AccountNumberFormatNumeric, prefix: "sbx_", number: "prod_1234567897", wantErr: "another environment"},
+		{name: "other environment with uuid format", format: AccountNumberFormatUUID, prefix: "sbx_", number: "prod_" + uuidNumber, wantErr: "another environment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDestinationNumber(tt.format, tt.prefix, tt.number)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
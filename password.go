@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// bcryptSlots bounds how many bcrypt hashes and comparisons run at once.
+// Each one pins a core for tens of milliseconds, so a login storm would
+// otherwise starve every other request of CPU; callers beyond the limit
+// queue for a slot until their request is done.
+var bcryptSlots = make(chan struct{}, runtime.NumCPU())
+
+// setBcryptConcurrency resizes the bcrypt limit. It must be called before
+// the server starts; zero or less uses one slot per CPU.
+func setBcryptConcurrency(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	bcryptSlots = make(chan struct{}, n)
+}
+
+// acquireBcryptSlot waits for a bcrypt slot. It gives up with
+// errServerBusy when ctx ends first, so a client that has gone away, or a
+// request past its timeout, stops holding a place in the queue.
+func acquireBcryptSlot(ctx context.Context) (release func(), err error) {
+	slots := bcryptSlots
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, errServerBusy
+	}
+}
+
+// PasswordPolicy is the strength a new password needs, checked when an
+// account is created and when its password is changed. Zero fields are not
+// enforced.
+type PasswordPolicy struct {
+	// MinLength counts characters, not bytes.
+	MinLength int `json:"min_length"`
+	// MinClasses is how many of lowercase, uppercase, digits and symbols
+	// the password has to mix.
+	MinClasses int `json:"min_classes"`
+	// MinEntropyBits is checked against a brute-force estimate: length
+	// times log2 of the size of the character classes used.
+	MinEntropyBits float64 `json:"min_entropy_bits"`
+}
+
+// passwordClasses are the character classes a password can draw from, with
+// roughly how many characters each contributes to a brute-force search.
+var passwordClasses = []struct {
+	name string
+	size int
+	in   func(rune) bool
+}{
+	{"a lowercase letter", 26, unicode.IsLower},
+	{"an uppercase letter", 26, unicode.IsUpper},
+	{"a digit", 10, unicode.IsDigit},
+	{"a symbol", 33, func(r rune) bool {
+		return !unicode.IsLower(r) && !unicode.IsUpper(r) && !unicode.IsDigit(r)
+	}},
+}
+
+// Check returns an error telling the user how to strengthen pw, or nil
+// when it satisfies the policy.
+func (p PasswordPolicy) Check(pw string) error {
+	length := len([]rune(pw))
+
+	var missing []string
+	classes, charset := 0, 0
+	for _, class := range passwordClasses {
+		if strings.IndexFunc(pw, class.in) >= 0 {
+			classes++
+			charset += class.size
+		} else {
+			missing = append(missing, class.name)
+		}
+	}
+
+	var advice []string
+	if length < p.MinLength {
+		advice = append(advice, fmt.Sprintf("use at least %d characters", p.MinLength))
+	}
+	if classes < p.MinClasses {
+		advice = append(advice, fmt.Sprintf("mix at least %d kinds of characters by adding %s", p.MinClasses, strings.Join(missing, " or ")))
+	}
+	if len(advice) == 0 && p.MinEntropyBits > 0 && passwordEntropy(length, charset) < p.MinEntropyBits {
+		advice = append(advice, "make it longer or add more kinds of characters")
+	}
+	if len(advice) > 0 {
+		return fmt.Errorf("password is too weak: %s", strings.Join(advice, "; "))
+	}
+	return nil
+}
+
+func passwordEntropy(length, charset int) float64 {
+	if charset == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(charset))
+}
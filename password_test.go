@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireBcryptSlotGivesUpWithContext(t *testing.T) {
+	defer setBcryptConcurrency(0)
+	setBcryptConcurrency(1)
+
+	release, err := acquireBcryptSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquiring the free slot: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireBcryptSlot(ctx); !errors.Is(err, errServerBusy) {
+		t.Fatalf("acquiring a taken slot: got %v, want errServerBusy", err)
+	}
+
+	release()
+	release, err = acquireBcryptSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquiring the released slot: %v", err)
+	}
+	release()
+}
+
+func TestPasswordHashingBusyWhenSlotsAreTaken(t *testing.T) {
+	defer setBcryptConcurrency(0)
+	setBcryptConcurrency(1)
+
+	acc := &Account{}
+	release, err := acquireBcryptSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := acc.ValidatePassword(ctx, "pw", Pepper{}); !errors.Is(err, errServerBusy) {
+		t.Fatalf("got %v, want errServerBusy", err)
+	}
+	if _, err := hashPassword(ctx, "pw", ""); !errors.Is(err, errServerBusy) {
+		t.Fatalf("got %v, want errServerBusy", err)
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PasswordPolicy
+		pw      string
+		wantErr string
+	}{
+		{name: "no policy", policy: PasswordPolicy{}, pw: ""},
+		{name: "long enough", policy: PasswordPolicy{MinLength: 8}, pw: "abcdefgh"},
+		{name: "too short", policy: PasswordPolicy{MinLength: 8}, pw: "abcdefg", wantErr: "use at least 8 characters"},
+		{name: "length counts characters", policy: PasswordPolicy{MinLength: 4}, pw: "äöü", wantErr: "use at least 4 characters"},
+		{name: "enough classes", policy: PasswordPolicy{MinClasses: 3}, pw: "abcD1"},
+		{name: "too few classes", policy: PasswordPolicy{MinClasses: 3}, pw: "abcdef", wantErr: "adding an uppercase letter or a digit or a symbol"},
+		{name: "symbol counts as a class", policy: PasswordPolicy{MinClasses: 2}, pw: "abc def"},
+		{name: "both failures", policy: PasswordPolicy{MinLength: 10, MinClasses: 2}, pw: "abc", wantErr: "use at least 10 characters; mix at least 2 kinds"},
+		{name: "enough entropy", policy: PasswordPolicy{MinEntropyBits: 60}, pw: "Abcdefgh1!"},
+		{name: "too little entropy", policy: PasswordPolicy{MinEntropyBits: 40}, pw: "abcdefgh", wantErr: "make it longer"},
+		{name: "empty has no entropy", policy: PasswordPolicy{MinEntropyBits: 1}, pw: "", wantErr: "make it longer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.pw)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateAccountEnforcesPasswordPolicy(t *testing.T) {
+	config := &Config{
+		Currency:              "USD",
+		AccountNumberFormat:   AccountNumberFormatUUID,
+		AccountNumberAttempts: 1,
+		PasswordPolicy:        PasswordPolicy{MinLength: 12, MinClasses: 2},
+	}
+	server := NewApiServer("", NewMemoryStore(), config)
+
+	tests := []struct {
+		name       string
+		pw         string
+		wantStatus int
+		wantInBody string
+	}{
+		{"strong", "correct horse battery 9", http.StatusCreated, `"number"`},
+		{"short", "Short1", http.StatusBadRequest, "use at least 12 characters"},
+		{"one class", "onlylowercaseletters", http.StatusBadRequest, "mix at least 2 kinds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"first_name":"Ada","last_name":"Lovelace","password":"` + tt.pw + `"}`
+			rec := serveTest(server, http.MethodPost, "/accounts", "", body)
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantInBody) {
+				t.Errorf("got %d %s, want %d with %q", rec.Code, rec.Body, tt.wantStatus, tt.wantInBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiterPruneSize is how many keys a limiter may track before an
+// allow sweeps out the ones whose window has passed.
+const rateLimiterPruneSize = 1024
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter allows each key limit calls per fixed window. Like the token
+// cache it is per process, so behind a load balancer the effective limit
+// scales with the number of instances.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[int64]*rateWindow
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: map[int64]*rateWindow{},
+	}
+}
+
+// allow counts a call by key at now. When the key is over its limit it
+// returns false and how long until its window resets.
+func (l *rateLimiter) allow(key int64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.windows) >= rateLimiterPruneSize {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// inflightLimiter caps how many calls per key may run at once. Keys are
+// dropped as soon as their last call finishes, so it never needs pruning.
+// It is per process like rateLimiter.
+type inflightLimiter struct {
+	limit int
+
+	mu       sync.Mutex
+	inflight map[int64]int
+}
+
+func newInflightLimiter(limit int) *inflightLimiter {
+	return &inflightLimiter{limit: limit, inflight: map[int64]int{}}
+}
+
+// acquire starts a call by key, returning false when key already has limit
+// calls running. Otherwise release must be called once the call is done.
+func (l *inflightLimiter) acquire(key int64) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight[key] >= l.limit {
+		return nil, false
+	}
+	l.inflight[key]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.inflight[key]--; l.inflight[key] <= 0 {
+			delete(l.inflight, key)
+		}
+	}, true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	type call struct {
+		key       int64
+		after     time.Duration
+		wantOK    bool
+		wantRetry time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit int
+		calls []call
+	}{
+		{"under the limit", 3, []call{
+			{1, 0, true, 0},
+			{1, time.Second, true, 0},
+			{1, 2 * time.Second, true, 0},
+		}},
+		{"over the limit", 2, []call{
+			{1, 0, true, 0},
+			{1, 10 * time.Second, true, 0},
+			{1, 20 * time.Second, false, 40 * time.Second},
+			{1, 59 * time.Second, false, time.Second},
+		}},
+		{"window resets", 1, []call{
+			{1, 0, true, 0},
+			{1, 30 * time.Second, false, 30 * time.Second},
+			{1, time.Minute, true, 0},
+			{1, time.Minute + time.Second, false, 59 * time.Second},
+		}},
+		{"keys are separate", 1, []call{
+			{1, 0, true, 0},
+			{2, 0, true, 0},
+			{1, time.Second, false, 59 * time.Second},
+			{2, time.Second, false, 59 * time.Second},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.limit, time.Minute)
+			for i, c := range tt.calls {
+				ok, retry := l.allow(c.key, start.Add(c.after))
+				if ok != c.wantOK || retry != c.wantRetry {
+					t.Fatalf("call %d: allow = %v, %s, want %v, %s", i, ok, retry, c.wantOK, c.wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPrunesExpiredWindows(t *testing.T) {
+	l := newRateLimiter(1, time.Minute)
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	for key := int64(0); key < rateLimiterPruneSize; key++ {
+		l.allow(key, start)
+	}
+
+	l.allow(-1, start.Add(time.Minute))
+	if len(l.windows) != 1 {
+		t.Errorf("%d windows tracked after the sweep, want 1", len(l.windows))
+	}
+}
+
+func TestHandleVerifyRecipientRateLimited(t *testing.T) {
+	store := NewMemoryStore()
+	caller := newTestAccount(t, store, 0)
+	recipient := newTestAccount(t, store, 0)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID, RecipientVerifyLimit: 2})
+	token := testToken(t, caller)
+
+	path := "/accounts/verify?number=" + recipient.Number
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := serveTest(server, http.MethodGet, path, token, "")
+		if rec.Code != want {
+			t.Fatalf("lookup %d: status = %d, want %d: %s", i, rec.Code, want, rec.Body)
+		}
+		if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+
+	other := newTestAccount(t, store, 0)
+	if rec := serveTest(server, http.MethodGet, path, testToken(t, other), ""); rec.Code != http.StatusOK {
+		t.Errorf("another caller got %d, want 200", rec.Code)
+	}
+}
+
+func TestInflightLimiter(t *testing.T) {
+	l := newInflightLimiter(2)
+
+	first, ok := l.acquire(1)
+	if !ok {
+		t.Fatal("first call refused")
+	}
+	second, ok := l.acquire(1)
+	if !ok {
+		t.Fatal("second call refused")
+	}
+	if _, ok := l.acquire(1); ok {
+		t.Fatal("third concurrent call allowed")
+	}
+	other, ok := l.acquire(2)
+	if !ok {
+		t.Fatal("another key refused")
+	}
+
+	first()
+	third, ok := l.acquire(1)
+	if !ok {
+		t.Fatal("call refused after a slot was released")
+	}
+	second()
+	third()
+	other()
+	if len(l.inflight) != 0 {
+		t.Errorf("%d keys still tracked after every call finished", len(l.inflight))
+	}
+}
+
+func TestHandleTransferInflightLimit(t *testing.T) {
+	store := NewMemoryStore()
+	from := newTestAccount(t, store, 1000)
+	to := newTestAccount(t, store, 0)
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID, MinTransferAmount: 1, TransferConcurrency: 1})
+	token := testToken(t, from)
+	body := `{"to_account":"` + to.Number + `","amount":10}`
+
+	release, ok := server.transferLimiter.acquire(from.ID)
+	if !ok {
+		t.Fatal("could not take the account's transfer slot")
+	}
+	rec := serveTest(server, http.MethodPost, "/transfer", token, body)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("transfer with the slot taken: status = %d, want 429: %s", rec.Code, rec.Body)
+	}
+
+	// Another account's transfers are not held up.
+	if rec := serveTest(server, http.MethodPost, "/transfer", testToken(t, to), `{"to_account":"`+from.Number+`","amount":1}`); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("another account was limited")
+	}
+
+	release()
+	if rec := serveTest(server, http.MethodPost, "/transfer", token, body); rec.Code != http.StatusOK {
+		t.Fatalf("transfer after release: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
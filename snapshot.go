@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// BalanceSnapshot is an account's balance as recorded by the snapshot job,
+// for charting balances over time without replaying the ledger.
+type BalanceSnapshot struct {
+	AccountID int64     `json:"-"`
+	Balance   Money     `json:"balance"`
+	TakenAt   Timestamp `json:"taken_at"`
+}
+
+// snapshotSettleDelay is how long after a snapshot's moment the job waits
+// before taking it. Ledger lines are dated when they are written, not when
+// their transaction commits, so the delay has to outlast any transaction
+// that posts lines.
+const snapshotSettleDelay = 10 * time.Minute
+
+// SnapshotJob records every account's balance once per interval.
+type SnapshotJob struct {
+	store    Storage
+	interval time.Duration
+}
+
+func NewSnapshotJob(store Storage, config *Config) *SnapshotJob {
+	return &SnapshotJob{
+		store:    store,
+		interval: config.SnapshotInterval,
+	}
+}
+
+// Run takes the latest settled snapshot straight away, then each following
+// one as soon as it has settled, until ctx is cancelled. Taking a snapshot
+// twice is harmless, so restarts need not track which were taken.
+func (j *SnapshotJob) Run(ctx context.Context) {
+	for {
+		takenAt := time.Now().UTC().Add(-snapshotSettleDelay).Truncate(j.interval)
+		if _, err := j.store.SnapshotBalances(ctx, takenAt); err != nil {
+			log.Println("balance snapshot failed:", err)
+		}
+
+		next := time.Until(takenAt.Add(j.interval + snapshotSettleDelay))
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// handleGetBalanceSnapshots pages through the account's snapshots, oldest
+// first. from is inclusive and to exclusive; either may be left out.
+func (s *ApiServer) handleGetBalanceSnapshots(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	from, err := getTimeParam(r, "from", time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := getTimeParam(r, "to", time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	limit, offset, err := getPagination(r)
+	if err != nil {
+		return err
+	}
+
+	snapshots, total, err := s.store.GetBalanceSnapshots(r.Context(), int64(id), from, to, limit, offset)
+	if err != nil {
+		return err
+	}
+	return writeList(w, r, ListResponse{
+		Data:   snapshots,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxStatementEntries bounds how many ledger lines one statement carries.
+// Longer periods have to be exported in pieces.
+const maxStatementEntries = 5000
+
+// ofxBankID stands in for the routing number OFX wants in an account
+// reference. Importers only use it to tell banks apart.
+const ofxBankID = "GOBANK"
+
+// handleGetStatement exports the account's ledger lines created in [from,
+// to) for accounting software. from defaults to the account's opening and
+// to to now; OFX is the only format so far.
+func (s *ApiServer) handleGetStatement(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if format := r.URL.Query().Get("format"); format != "ofx" {
+		return fmt.Errorf("invalid format given %q: must be %q", format, "ofx")
+	}
+
+	now := time.Now().UTC()
+	from, err := getTimeParam(r, "from", time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := getTimeParam(r, "to", now)
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	filter := TransactionFilter{From: from, To: to}
+	entries, total, err := s.store.GetTransactions(r.Context(), int64(id), filter, maxStatementEntries, 0)
+	if err != nil {
+		return err
+	}
+	if total > maxStatementEntries {
+		return fmt.Errorf("statement would have %d transactions, more than the %d allowed: narrow the date range", total, maxStatementEntries)
+	}
+
+	balance, err := s.store.GetBalanceAsOf(r.Context(), int64(id), to)
+	if err != nil {
+		return err
+	}
+
+	doc := newOFXStatement(account, s.currency, entries, balance, from, to, now)
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/x-ofx")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "statement-"+account.Number+".ofx"))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write([]byte(ofxProcessingInstruction + "\n"))
+	w.Write(body)
+	return nil
+}
+
+// ofxProcessingInstruction marks the document as OFX 2.2, the XML flavour
+// of the format that Quicken and GnuCash both import.
+const ofxProcessingInstruction = `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`
+
+type ofxDocument struct {
+	XMLName xml.Name     `xml:"OFX"`
+	SignOn  ofxSignOn    `xml:"SIGNONMSGSRSV1>SONRS"`
+	Bank    ofxStmtTrnRs `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxSignOn struct {
+	Status   ofxStatus `xml:"STATUS"`
+	DTServer string    `xml:"DTSERVER"`
+	Language string    `xml:"LANGUAGE"`
+}
+
+type ofxStmtTrnRs struct {
+	TrnUID    string    `xml:"TRNUID"`
+	Status    ofxStatus `xml:"STATUS"`
+	Statement ofxStmtRs `xml:"STMTRS"`
+}
+
+type ofxStmtRs struct {
+	CurDef       string         `xml:"CURDEF"`
+	Account      ofxBankAccount `xml:"BANKACCTFROM"`
+	Transactions ofxTranList    `xml:"BANKTRANLIST"`
+	LedgerBal    ofxBalance     `xml:"LEDGERBAL"`
+}
+
+type ofxBankAccount struct {
+	BankID   string `xml:"BANKID"`
+	AcctID   string `xml:"ACCTID"`
+	AcctType string `xml:"ACCTTYPE"`
+}
+
+type ofxTranList struct {
+	DTStart      string           `xml:"DTSTART"`
+	DTEnd        string           `xml:"DTEND"`
+	Transactions []ofxTransaction `xml:"STMTTRN"`
+}
+
+type ofxTransaction struct {
+	TrnType  string `xml:"TRNTYPE"`
+	DTPosted string `xml:"DTPOSTED"`
+	TrnAmt   string `xml:"TRNAMT"`
+	FITID    string `xml:"FITID"`
+	Name     string `xml:"NAME"`
+	Memo     string `xml:"MEMO,omitempty"`
+}
+
+type ofxBalance struct {
+	BalAmt string `xml:"BALAMT"`
+	DTAsOf string `xml:"DTASOF"`
+}
+
+// ofxDecimal is how OFX wants amounts: a plain decimal in major units.
+var ofxDecimal = moneyLocale{decimal: "."}
+
+func newOFXStatement(account *Account, currency Currency, entries []*LedgerEntry, balance Money, from, to, now time.Time) ofxDocument {
+	ok := ofxStatus{Code: 0, Severity: "INFO"}
+
+	acctType := "CHECKING"
+	if account.Type == AccountTypeSavings {
+		acctType = "SAVINGS"
+	}
+
+	// Entries come newest first; statements read oldest first.
+	transactions := make([]ofxTransaction, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		transactions = append(transactions, ofxTransaction{
+			TrnType:  ofxTransactionType(entry),
+			DTPosted: ofxTime(entry.CreatedAt.Time),
+			TrnAmt:   currency.Format(entry.Amount, ofxDecimal),
+			FITID:    strconv.FormatInt(entry.ID, 10),
+			Name:     entry.Kind,
+			Memo:     entry.TransactionID,
+		})
+	}
+
+	if from.IsZero() {
+		from = account.CreatedAt.Time
+	}
+
+	return ofxDocument{
+		SignOn: ofxSignOn{Status: ok, DTServer: ofxTime(now), Language: "ENG"},
+		Bank: ofxStmtTrnRs{
+			TrnUID: "0",
+			Status: ok,
+			Statement: ofxStmtRs{
+				CurDef:  currency.Code,
+				Account: ofxBankAccount{BankID: ofxBankID, AcctID: account.Number, AcctType: acctType},
+				Transactions: ofxTranList{
+					DTStart:      ofxTime(from),
+					DTEnd:        ofxTime(to),
+					Transactions: transactions,
+				},
+				LedgerBal: ofxBalance{BalAmt: currency.Format(balance, ofxDecimal), DTAsOf: ofxTime(to)},
+			},
+		},
+	}
+}
+
+func ofxTransactionType(entry *LedgerEntry) string {
+	switch {
+	case entry.Kind == LedgerKindInterest:
+		return "INT"
+	case entry.Kind == LedgerKindOpeningDeposit:
+		return "DEP"
+	case entry.Amount < 0:
+		return "DEBIT"
+	}
+	return "CREDIT"
+}
+
+// ofxTime formats t as an OFX datetime, always in UTC.
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:UTC]"
+}
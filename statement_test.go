@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOFXTransactionType(t *testing.T) {
+	tests := []struct {
+		kind   string
+		amount Money
+		want   string
+	}{
+		{LedgerKindInterest, 12, "INT"},
+		{LedgerKindOpeningDeposit, 1000, "DEP"},
+		{LedgerKindTransfer, -500, "DEBIT"},
+		{LedgerKindTransfer, 500, "CREDIT"},
+	}
+	for _, tt := range tests {
+		if got := ofxTransactionType(&LedgerEntry{Kind: tt.kind, Amount: tt.amount}); got != tt.want {
+			t.Errorf("%s %d: got %s, want %s", tt.kind, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestNewOFXStatement(t *testing.T) {
+	opened := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	to := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*LedgerEntry{
+		{ID: 3, TransactionID: "tx-2", Amount: -250, Kind: LedgerKindTransfer, CreatedAt: NewTimestamp(opened.Add(2 * time.Hour))},
+		{ID: 2, TransactionID: "tx-1", Amount: 1000, Kind: LedgerKindTransfer, CreatedAt: NewTimestamp(opened.Add(time.Hour))},
+	}
+
+	tests := []struct {
+		currency    string
+		accountType string
+		wantAmounts []string
+		wantBalance string
+		wantType    string
+	}{
+		{"USD", AccountTypeChecking, []string{"10.00", "-2.50"}, "7.50", "CHECKING"},
+		{"JPY", AccountTypeSavings, []string{"1000", "-250"}, "750", "SAVINGS"},
+		{"KWD", AccountTypeChecking, []string{"1.000", "-0.250"}, "0.750", "CHECKING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			account := &Account{Number: "1234567897", Type: tt.accountType, CreatedAt: NewTimestamp(opened)}
+			doc := newOFXStatement(account, currencies[tt.currency], entries, 750, time.Time{}, to, to)
+			stmt := doc.Bank.Statement
+
+			if stmt.CurDef != tt.currency || stmt.Account.AcctID != account.Number || stmt.Account.AcctType != tt.wantType {
+				t.Errorf("header = %+v", stmt)
+			}
+			if stmt.Transactions.DTStart != "20260102030405.000[0:UTC]" || stmt.Transactions.DTEnd != "20260201000000.000[0:UTC]" {
+				t.Errorf("range = %s to %s, want the account's opening to the end", stmt.Transactions.DTStart, stmt.Transactions.DTEnd)
+			}
+			if len(stmt.Transactions.Transactions) != 2 {
+				t.Fatalf("%d transactions, want 2", len(stmt.Transactions.Transactions))
+			}
+			for i, trn := range stmt.Transactions.Transactions {
+				if trn.TrnAmt != tt.wantAmounts[i] {
+					t.Errorf("transaction %d: amount %s, want %s", i, trn.TrnAmt, tt.wantAmounts[i])
+				}
+			}
+			if first := stmt.Transactions.Transactions[0]; first.FITID != "2" || first.Memo != "tx-1" || first.TrnType != "CREDIT" {
+				t.Errorf("first transaction = %+v, want the oldest entry", first)
+			}
+			if stmt.LedgerBal.BalAmt != tt.wantBalance {
+				t.Errorf("balance = %s, want %s", stmt.LedgerBal.BalAmt, tt.wantBalance)
+			}
+		})
+	}
+}
+
+func TestHandleGetStatement(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 10000)
+	other := newTestAccount(t, store, 0)
+	if _, err := store.Transfer(context.Background(), acc.Number, other.Number, 2550, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+	token := testToken(t, acc)
+	path := fmt.Sprintf("/accounts/%d/statement", acc.ID)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantInBody string
+	}{
+		{"?format=ofx", http.StatusOK, "<BALAMT>74.50</BALAMT>"},
+		{"?format=csv", http.StatusBadRequest, `invalid format given \"csv\"`},
+		{"", http.StatusBadRequest, "invalid format"},
+		{"?format=ofx&from=2026-02-01T00:00:00Z&to=2026-01-01T00:00:00Z", http.StatusBadRequest, "from must be before to"},
+		{"?format=ofx&from=yesterday", http.StatusBadRequest, "must be an RFC3339 timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := serveTest(server, http.MethodGet, path+tt.query, token, "")
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantInBody) {
+				t.Fatalf("got %d %s, want %d with %q", rec.Code, rec.Body, tt.wantStatus, tt.wantInBody)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ofx" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			body := rec.Body.String()
+			if !strings.HasPrefix(body, xml.Header+ofxProcessingInstruction+"\n") {
+				t.Errorf("body does not start with the OFX headers: %.120s", body)
+			}
+			var doc ofxDocument
+			if err := xml.Unmarshal([]byte(body[len(xml.Header):]), &doc); err != nil {
+				t.Fatal(err)
+			}
+			var amounts []string
+			for _, trn := range doc.Bank.Statement.Transactions.Transactions {
+				amounts = append(amounts, trn.TrnType+" "+trn.TrnAmt)
+			}
+			if got := strings.Join(amounts, ", "); got != "DEP 100.00, DEBIT -25.50" {
+				t.Errorf("transactions = %s", got)
+			}
+		})
+	}
+}
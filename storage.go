@@ -1,134 +1,2277 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// accountColumns lists the accounts columns in the order scanIntoAccount
+// reads them.
+const accountColumns = "id, first_name, last_name, number, encrypted_password, balance, role, account_type, status, created_at, purge_at"
+
+// ErrAccountNotFound is wrapped by lookups that match no account.
+var ErrAccountNotFound = newStatusError(http.StatusNotFound, "not found")
+
+// ErrSystemAccount refuses user transfers touching an account the bank
+// keeps for itself, such as the fee account.
+var ErrSystemAccount = newStatusError(http.StatusForbidden, "transfers to or from system accounts are not allowed")
+
+// ErrSchemaMissing replaces the driver's errors for tables or columns that
+// do not exist, which mean the schema was never created or is out of date.
+var ErrSchemaMissing = newStatusError(http.StatusInternalServerError,
+	"database schema is missing or out of date; start the server against this database so Init can create it")
+
+// Postgres error codes for a missing table and a missing column.
+const (
+	pqUndefinedTable  = "42P01"
+	pqUndefinedColumn = "42703"
+)
+
+// schemaError turns a missing table or column error into ErrSchemaMissing,
+// keeping the driver's message, which names what is missing. Other errors
+// are returned as they are.
+func schemaError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == pqUndefinedTable || pqErr.Code == pqUndefinedColumn) {
+		return fmt.Errorf("%w (%s)", ErrSchemaMissing, pqErr.Message)
+	}
+	return err
+}
+
+// PartialResultsError is returned alongside the rows that could be read
+// when a listing skipped rows that failed to scan.
+type PartialResultsError struct {
+	Skipped int
+}
+
+func (e *PartialResultsError) Error() string {
+	return fmt.Sprintf("%d rows could not be read", e.Skipped)
+}
+
+// ErrAccountHasFunds is returned when closing an account that still holds a
+// balance; the money has to be swept elsewhere first.
+var ErrAccountHasFunds = newStatusError(http.StatusConflict, "account balance must be zero before it is closed")
+
+// ErrAccountNotPending is returned when approving or rejecting an account
+// that has already been decided.
+var ErrAccountNotPending = newStatusError(http.StatusConflict, "account is not pending approval")
+
 type Storage interface {
-	GetAccounts() ([]*Account, error)
-	GetAccountByID(int) (*Account, error)
-	GetAccountByNumber(string) (*Account, error)
-	CreateAccount(*Account) error
-	DeleteAccount(int) (int, error)
-	Transfer(string, float64) (int, error)
+	PoolStats() []*PoolStats
+	GetAccounts(ctx context.Context, limit, offset int) ([]*Account, int, error)
+	GetAccountsCreatedBetween(ctx context.Context, from, to time.Time, status string, limit, offset int) ([]*Account, int, error)
+	GetAccountByID(context.Context, int) (*Account, error)
+	GetAccountsByType(ctx context.Context, accountType string) ([]*Account, error)
+	GetAccountByNumber(context.Context, string) (*Account, error)
+	AccountExists(ctx context.Context, number string) (bool, error)
+	NextAccountNumber(context.Context) (int64, error)
+	CreateAccount(context.Context, *Account) error
+	CreateAccountOnce(ctx context.Context, acc *Account, key string, since time.Time) (*Account, error)
+	AnonymizeAccount(context.Context, int) (int, error)
+	CloseAccount(ctx context.Context, id int, purgeAt time.Time) (int, error)
+	RestoreAccount(ctx context.Context, id int, now time.Time) (*Account, error)
+	PurgeClosedAccounts(ctx context.Context, now time.Time) (int, error)
+	AnonymizeAccounts(ctx context.Context, ids []int) ([]*DeleteResult, error)
+	DecideAccount(ctx context.Context, id int, status string, event *AuditEvent) (*Account, error)
+	LockAccount(ctx context.Context, id int64) (*Account, func(commit bool) error, error)
+	FreezeAccounts(ctx context.Context, filter AccountFilter, event *AuditEvent, dryRun bool) (int, error)
+	UpdatePassword(ctx context.Context, id int64, encryptedPassword string) error
+	ChangePassword(ctx context.Context, id int64, encryptedPassword string, keep int) error
+	GetPasswordHistory(ctx context.Context, id int64, limit int) ([]string, error)
+	Transfer(ctx context.Context, fromNumber, toNumber string, amount Money, category string, metadata Metadata) (*TransferResult, error)
+	Sweep(ctx context.Context, fromID int64, toNumber string) (Money, error)
+	PostEntries(context.Context, []*LedgerEntry) error
+	PostInterest(ctx context.Context, accountID int64, period string, amount Money) (bool, error)
+	GetTransactions(ctx context.Context, accountID int64, filter TransactionFilter, limit, offset int) ([]*LedgerEntry, int, error)
+	GetAllTransactions(ctx context.Context, filter TransactionFilter, limit, offset int) ([]*LedgerEntry, int, error)
+	GetBalanceAsOf(ctx context.Context, accountID int64, before time.Time) (Money, error)
+	GetBalanceMismatches(context.Context) ([]*BalanceMismatch, error)
+	VerifyLedger(ctx context.Context, id int64, repair *AuditEvent) (*LedgerVerification, error)
+	GetCategoryTotals(ctx context.Context, accountID int64, from, to time.Time) ([]*CategoryTotal, error)
+	GetFlowTotals(ctx context.Context, accountID int64, from, to time.Time) (inflows, outflows Money, err error)
+	SnapshotBalances(ctx context.Context, takenAt time.Time) (int, error)
+	GetBalanceSnapshots(ctx context.Context, accountID int64, from, to time.Time, limit, offset int) ([]*BalanceSnapshot, int, error)
+	ClaimPendingWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error)
+	MarkWebhookSent(ctx context.Context, id int64) error
+	MarkWebhookRetry(ctx context.Context, id int64, nextAttemptAt time.Time) error
+	MarkWebhookFailed(ctx context.Context, id int64) error
+	CreateAuditEvent(context.Context, *AuditEvent) error
+	CountLoginFailuresSince(ctx context.Context, accountID int64, since time.Time) (int, error)
+	GetLoginHistory(ctx context.Context, accountID int64, limit, offset int) ([]*AuditEvent, int, error)
+	GetTokenEpoch(context.Context) (time.Time, error)
+	BumpTokenEpoch(context.Context) (time.Time, error)
+	CreateBeneficiary(context.Context, *Beneficiary) error
+	GetBeneficiaries(ctx context.Context, accountID int64) ([]*Beneficiary, error)
+	GetBeneficiary(ctx context.Context, accountID, id int64) (*Beneficiary, error)
+	DeleteBeneficiary(ctx context.Context, accountID, id int64) error
+	GetTransferWhitelist(ctx context.Context, accountID int64) (*TransferWhitelist, error)
+	SetTransferWhitelistEnabled(ctx context.Context, accountID int64, enabled bool) error
+	AddWhitelistEntry(context.Context, *WhitelistEntry) error
+	DeleteWhitelistEntry(ctx context.Context, accountID int64, number string) error
+	CreateAPIKey(context.Context, *APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context, accountID int64) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, accountID, id int64) error
+}
+
+type PostgresStore struct {
+	db             *breakerDB
+	replicas       []*breakerDB
+	nextReplica    uint64
+	queryTimeout   time.Duration
+	recordWebhooks bool
+	// skipBadRows makes account listings skip rows that fail to scan
+	// rather than fail outright; see PartialResultsError.
+	skipBadRows bool
+}
+
+func NewPostgresStore(config *Config) (*PostgresStore, error) {
+	db, err := openDB(config.DatabaseURL, config)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]*breakerDB, 0, len(config.DBReplicaURLs))
+	for i, url := range config.DBReplicaURLs {
+		replica, err := openDB(url, config)
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %w", i, err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	return &PostgresStore{
+		db:             db,
+		replicas:       replicas,
+		queryTimeout:   config.DBQueryTimeout,
+		recordWebhooks: config.WebhookURL != "",
+		skipBadRows:    config.AccountListSkipBadRows,
+	}, nil
+}
+
+// openDB connects to one database, each behind its own circuit breaker so
+// a failing replica does not trip the primary.
+func openDB(connStr string, config *Config) (*breakerDB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(config.DBMaxOpenConns)
+	db.SetMaxIdleConns(config.DBMaxIdleConns)
+	db.SetConnMaxIdleTime(config.DBConnMaxIdleTime)
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+	return &breakerDB{
+		DB:        db,
+		breaker:   NewCircuitBreaker(config.DBBreakerThreshold, config.DBBreakerCooldown),
+		slowQuery: config.DBSlowQueryThreshold,
+	}, nil
+}
+
+// checkConnString parses connStr the way the driver will, so a malformed
+// one is reported at startup rather than as a confusing connect failure.
+// URL parse errors, which repeat the URL and any password in it, are cut
+// down to their cause.
+func checkConnString(connStr string) error {
+	if scheme, _, ok := strings.Cut(connStr, "://"); ok && scheme != "postgres" && scheme != "postgresql" {
+		return fmt.Errorf("scheme must be postgres:// or postgresql://, got %s://", scheme)
+	}
+	_, err := pq.NewConnector(connStr)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// PoolStats reports the connection pool of the primary and then of each
+// replica.
+func (s *PostgresStore) PoolStats() []*PoolStats {
+	stats := []*PoolStats{newPoolStats("primary", s.db.Stats())}
+	for i, replica := range s.replicas {
+		stats = append(stats, newPoolStats(fmt.Sprintf("replica %d", i), replica.Stats()))
+	}
+	return stats
+}
+
+// reader picks the database for a read that may lag behind the latest
+// writes, taking the replicas in turn. Replicas whose breaker is open are
+// skipped, and the primary serves the read when there are no replicas or
+// none of them is healthy.
+func (s *PostgresStore) reader() *breakerDB {
+	n := atomic.AddUint64(&s.nextReplica, 1)
+	for i := range s.replicas {
+		replica := s.replicas[(n+uint64(i))%uint64(len(s.replicas))]
+		if !replica.breaker.Open() {
+			return replica
+		}
+	}
+	return s.db
+}
+
+// withTimeout bounds a store call by the configured query timeout so a
+// slow statement cannot hold a request forever, even when the caller's
+// context has no deadline of its own.
+func (s *PostgresStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// GetAccounts pages through the accounts by id. With skipBadRows set, rows
+// that fail to scan are left out and reported with a PartialResultsError
+// next to the accounts that were read.
+func (s *PostgresStore) GetAccounts(ctx context.Context, limit, offset int) ([]*Account, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	rows, err := db.QueryContext(ctx, "select "+accountColumns+", count(*) over () from accounts where anonymized_at is null order by id limit $1 offset $2", limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	skipped := 0
+	total := 0
+	for rows.Next() {
+		acc, err := scanIntoAccount(rows, &total)
+		if err != nil && s.skipBadRows {
+			log.Println("skipping account row that failed to scan:", err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, acc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	total, err = pageTotal(ctx, db, total, len(accounts) > 0 || (offset == 0 && skipped == 0),
+		"select count(*) from accounts where anonymized_at is null")
+	if err != nil {
+		return nil, 0, err
+	}
+	if skipped > 0 {
+		return accounts, total, &PartialResultsError{Skipped: skipped}
+	}
+	return accounts, total, nil
+}
+
+// pageTotal returns the total a listing read from its count(*) over ()
+// column. When no row carried it, unless the listing is simply empty, the
+// page was past the end and countQuery is run to find the total.
+func pageTotal(ctx context.Context, db *breakerDB, total int, known bool, countQuery string, args ...any) (int, error) {
+	if known {
+		return total, nil
+	}
+	err := db.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	return total, err
+}
+
+// GetAccountsCreatedBetween pages through the accounts created in the
+// half-open range [from, to), oldest first. A non-empty status keeps only
+// accounts in that status.
+func (s *PostgresStore) GetAccountsCreatedBetween(ctx context.Context, from, to time.Time, status string, limit, offset int) ([]*Account, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	where := "anonymized_at is null and created_at >= $1 and created_at < $2 and ($3 = '' or status = $3)"
+	query := `
+		select ` + accountColumns + `, count(*) over ()
+		from accounts
+		where ` + where + `
+		order by created_at, id
+		limit $4 offset $5;`
+
+	rows, err := db.QueryContext(ctx, query, from, to, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	total := 0
+	for rows.Next() {
+		acc, err := scanIntoAccount(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, acc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	total, err = pageTotal(ctx, db, total, len(accounts) > 0 || offset == 0,
+		"select count(*) from accounts where "+where, from, to, status)
+	if err != nil {
+		return nil, 0, err
+	}
+	return accounts, total, nil
+}
+
+func (s *PostgresStore) GetAccountsByType(ctx context.Context, accountType string) ([]*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from accounts where anonymized_at is null and account_type = $1 order by id", accountType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		acc, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *PostgresStore) GetAccountByID(ctx context.Context, id int) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	rows, err := db.QueryContext(ctx, "select "+accountColumns+" from accounts where id = $1 and anonymized_at is null", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAccount(rows)
+	}
+	return nil, fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+}
+
+func (s *PostgresStore) GetAccountByNumber(ctx context.Context, number string) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from accounts where number = $1 and anonymized_at is null", number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAccount(rows)
+	}
+	return nil, fmt.Errorf("account %s %w", number, ErrAccountNotFound)
+}
+
+// AccountExists reports whether any account, closed ones included, has
+// the number. Closed accounts keep theirs as a tombstone, so it can never
+// be handed out again.
+func (s *PostgresStore) AccountExists(ctx context.Context, number string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "select exists(select 1 from accounts where number = $1)", number).Scan(&exists)
+	return exists, err
+}
+
+// NextAccountNumber draws the next value of the account number sequence,
+// counting from zero.
+func (s *PostgresStore) NextAccountNumber(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var n int64
+	err := s.db.QueryRowContext(ctx, "select nextval('account_number_seq')").Scan(&n)
+	return n, err
+}
+
+// CreateAccount inserts the account and, when it starts with a non-zero
+// balance, posts the opening deposit in a single transaction. If either
+// fails nothing is written and acc.ID is left unset.
+func (s *PostgresStore) CreateAccount(ctx context.Context, acc *Account) error {
+	opening := acc.Balance
+	acc.Balance = 0
+
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.createAccount(ctx, tx, acc, opening)
+	})
+	if err != nil {
+		acc.ID = 0
+	}
+	acc.Balance = opening
+	return err
+}
+
+// CreateAccountOnce is CreateAccount for retried signups. The first call
+// with key creates acc and remembers it under key; a later call with the
+// same key, while the key was stored no earlier than since, creates
+// nothing and returns the account made the first time instead. Keys older
+// than since are forgotten.
+func (s *PostgresStore) CreateAccountOnce(ctx context.Context, acc *Account, key string, since time.Time) (*Account, error) {
+	opening := acc.Balance
+	acc.Balance = 0
+
+	var existing *Account
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		// Concurrent retries with the same key wait here for the first one
+		// to commit, instead of both finding the key unused.
+		if _, err := tx.ExecContext(ctx, "select pg_advisory_xact_lock(hashtext($1))", key); err != nil {
+			return err
+		}
+
+		query := `
+			select ` + accountColumns + `
+			from accounts
+			where id = (
+				select account_id from account_idempotency_keys where key = $1 and created_at >= $2
+			);`
+
+		rows, err := tx.QueryContext(ctx, query, key, since)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			existing, err = scanIntoAccount(rows)
+			break
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil || existing != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "delete from account_idempotency_keys where created_at < $1", since); err != nil {
+			return err
+		}
+		if err := s.createAccount(ctx, tx, acc, opening); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"insert into account_idempotency_keys (key, account_id, created_at) values ($1, $2, $3)",
+			key, acc.ID, time.Now().UTC(),
+		)
+		return err
+	})
+	if err != nil || existing != nil {
+		acc.ID = 0
+	}
+	acc.Balance = opening
+	return existing, err
+}
+
+// createAccount inserts acc with its opening deposit inside tx.
+func (s *PostgresStore) createAccount(ctx context.Context, tx *sql.Tx, acc *Account, opening Money) error {
+	if err := insertAccount(ctx, tx, acc); err != nil {
+		return err
+	}
+	if opening != 0 {
+		err := postEntries(ctx, tx, []*LedgerEntry{
+			{AccountID: &acc.ID, Amount: opening, Kind: LedgerKindOpeningDeposit, CreatedAt: acc.CreatedAt},
+			{AccountID: nil, Amount: -opening, Kind: LedgerKindOpeningDeposit, CreatedAt: acc.CreatedAt},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.recordWebhooks {
+		return nil
+	}
+	return insertOutboxMessage(ctx, tx, WebhookEventAccountCreated, map[string]any{
+		"account_id": acc.ID,
+		"number":     acc.Number,
+	})
+}
+
+// AnonymizeAccount closes an account by scrubbing its personal data instead
+// of deleting the row. The row, with its id and number, stays behind as a
+// tombstone so ledger entries keep pointing at a real account, but it can
+// no longer log in, receive transfers or be looked up. It returns 0 when no
+// open account has the id.
+func (s *PostgresStore) AnonymizeAccount(ctx context.Context, id int) (int, error) {
+	anonymized := 0
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		ok, err := anonymizeAccount(ctx, tx, id)
+		if err != nil || !ok {
+			return err
+		}
+		anonymized = id
+		return s.recordAccountDeleted(ctx, tx, id)
+	})
+	return anonymized, err
+}
+
+func (s *PostgresStore) recordAccountDeleted(ctx context.Context, tx *sql.Tx, id int) error {
+	if !s.recordWebhooks {
+		return nil
+	}
+	return insertOutboxMessage(ctx, tx, WebhookEventAccountDeleted, map[string]any{"account_id": id})
+}
+
+// CloseAccount is the soft delete used while a deletion grace period is
+// configured: the account is closed, which keeps it from logging in or
+// moving money, and is anonymized by PurgeClosedAccounts once purgeAt has
+// passed. Like AnonymizeAccount it refuses accounts that still hold funds
+// and returns 0 when no open account has the id.
+func (s *PostgresStore) CloseAccount(ctx context.Context, id int, purgeAt time.Time) (int, error) {
+	closed := 0
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var balance Money
+		err := tx.QueryRowContext(ctx,
+			"select balance from accounts where id = $1 and anonymized_at is null and status <> 'closed' for update", id,
+		).Scan(&balance)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if balance != 0 {
+			return ErrAccountHasFunds
+		}
+
+		query := `
+			update accounts
+			set status_before_close = status, status = 'closed', purge_at = $2
+			where id = $1;`
+
+		if _, err := tx.ExecContext(ctx, query, id, purgeAt); err != nil {
+			return err
+		}
+		closed = id
+		return nil
+	})
+	return closed, err
+}
+
+// RestoreAccount reopens a closed account whose purge time is still after
+// now, with the status it had before it was closed.
+func (s *PostgresStore) RestoreAccount(ctx context.Context, id int, now time.Time) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		update accounts
+		set status = coalesce(status_before_close, 'active'), status_before_close = null, purge_at = null
+		where id = $1 and status = 'closed' and purge_at > $2 and anonymized_at is null
+		returning ` + accountColumns + `;`
+
+	rows, err := s.db.QueryContext(ctx, query, id, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAccount(rows)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrAccountNotRestorable
+}
+
+// PurgeClosedAccounts anonymizes every closed account whose purge time is
+// not after now, each in its own transaction so one failure does not hold
+// up the rest. It returns how many were purged, and an error naming every
+// account that could not be.
+func (s *PostgresStore) PurgeClosedAccounts(ctx context.Context, now time.Time) (int, error) {
+	ids, err := s.closedAccountsDue(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var failed []error
+	for _, id := range ids {
+		done := false
+		err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			// The account may have been restored since it was listed.
+			var due bool
+			err := tx.QueryRowContext(ctx,
+				"select coalesce(status = 'closed' and purge_at <= $2, false) from accounts where id = $1 for update", id, now,
+			).Scan(&due)
+			if err != nil || !due {
+				return err
+			}
+			ok, err := anonymizeAccount(ctx, tx, id)
+			if err != nil || !ok {
+				return err
+			}
+			done = true
+			return s.recordAccountDeleted(ctx, tx, id)
+		})
+		if err != nil {
+			failed = append(failed, fmt.Errorf("account %d: %w", id, err))
+			continue
+		}
+		if done {
+			purged++
+		}
+	}
+	if len(failed) > 0 {
+		return purged, fmt.Errorf("failed to purge %d of %d closed accounts: %w", len(failed), len(ids), errors.Join(failed...))
+	}
+	return purged, nil
+}
+
+func (s *PostgresStore) closedAccountsDue(ctx context.Context, now time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"select id from accounts where status = 'closed' and purge_at <= $1 and anonymized_at is null order by id", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AnonymizeAccounts closes the accounts in ids all at once or not at all,
+// reporting what happened to each. When any of them still holds funds the
+// whole batch is rolled back: the results say which accounts were in the
+// way and ErrAccountHasFunds is returned with them.
+func (s *PostgresStore) AnonymizeAccounts(ctx context.Context, ids []int) ([]*DeleteResult, error) {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	var results []*DeleteResult
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		byID := map[int]string{}
+		funded := false
+		// Locking in id order keeps two overlapping batches from
+		// deadlocking.
+		for _, id := range sorted {
+			if _, seen := byID[id]; seen {
+				continue
+			}
+			ok, err := anonymizeAccount(ctx, tx, id)
+			switch {
+			case errors.Is(err, ErrAccountHasFunds):
+				byID[id] = DeleteResultHasFunds
+				funded = true
+			case err != nil:
+				return err
+			case ok:
+				byID[id] = DeleteResultDeleted
+				if err := s.recordAccountDeleted(ctx, tx, id); err != nil {
+					return err
+				}
+			default:
+				byID[id] = DeleteResultNotFound
+			}
+		}
+
+		results = make([]*DeleteResult, 0, len(ids))
+		for _, id := range ids {
+			result := byID[id]
+			if funded && result == DeleteResultDeleted {
+				result = DeleteResultRolledBack
+			}
+			results = append(results, &DeleteResult{ID: id, Result: result})
+		}
+		if funded {
+			return ErrAccountHasFunds
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrAccountHasFunds) {
+		return nil, err
+	}
+	return results, err
+}
+
+// anonymizeAccount scrubs one account inside tx, reporting false when
+// there is no open account with that id.
+func anonymizeAccount(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	var balance int
+	err := tx.QueryRowContext(ctx, "select balance from accounts where id = $1 and anonymized_at is null for update", id).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if balance != 0 {
+		return false, ErrAccountHasFunds
+	}
+
+	// One statement per call: with arguments the driver prepares the
+	// query, and Postgres refuses to prepare several commands at once.
+	query := `
+		update accounts
+		set first_name = '', last_name = '', encrypted_password = '', anonymized_at = $2
+		where id = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, id, time.Now().UTC()); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from password_history where account_id = $1", id); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "update audit_log set ip = '', geo = null where account_id = $1", id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockAccount begins a transaction holding the account's row lock and
+// returns the account for a read-modify-write. Until done is called, other
+// writers to the account, transfers included, wait. done(true) saves the
+// account's names, role, type and status and commits; done(false) rolls
+// back. The balance is never written, since only ledger postings change
+// it. done must be called exactly once, on every path.
+func (s *PostgresStore) LockAccount(ctx context.Context, id int64) (*Account, func(commit bool) error, error) {
+	ctx, cancel := s.withTimeout(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	abort := func(err error) (*Account, func(bool) error, error) {
+		tx.Rollback()
+		cancel()
+		return nil, nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "select "+accountColumns+" from accounts where id = $1 and anonymized_at is null for update", id)
+	if err != nil {
+		return abort(err)
+	}
+	var acc *Account
+	for rows.Next() {
+		acc, err = scanIntoAccount(rows)
+		break
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return abort(err)
+	}
+	if acc == nil {
+		return abort(fmt.Errorf("account %d %w", id, ErrAccountNotFound))
+	}
+
+	done := func(commit bool) error {
+		defer cancel()
+		if !commit {
+			return tx.Rollback()
+		}
+
+		query := `
+			update accounts
+			set first_name = $2, last_name = $3, role = $4, account_type = $5, status = $6
+			where id = $1;`
+
+		if _, err := tx.ExecContext(ctx, query, acc.ID, acc.FirstName, acc.LastName, acc.Role, acc.Type, acc.Status); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	return acc, done, nil
+}
+
+// DecideAccount moves a pending account to status, active when approved or
+// rejected otherwise, and returns the updated account. event, naming the
+// admin who decided, is written to the audit log in the same transaction,
+// so no decision is ever left without its record.
+func (s *PostgresStore) DecideAccount(ctx context.Context, id int, status string, event *AuditEvent) (*Account, error) {
+	var account *Account
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			update accounts set status = $2
+			where id = $1 and status = 'pending' and anonymized_at is null
+			returning ` + accountColumns + `;`
+
+		rows, err := tx.QueryContext(ctx, query, id, status)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			account, err = scanIntoAccount(rows)
+			break
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return ErrAccountNotPending
+		}
+
+		event.AccountID = account.ID
+		return insertAuditEvent(ctx, tx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// FreezeAccounts freezes every active, non-admin account matching filter
+// and writes a copy of event to the audit log for each one, queueing an
+// account.frozen webhook alongside, all in one statement, and returns how
+// many were frozen. With dryRun it only counts
+// them. Admins are left out so a broad filter cannot lock out the admins
+// who would undo it.
+func (s *PostgresStore) FreezeAccounts(ctx context.Context, filter AccountFilter, event *AuditEvent, dryRun bool) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where := `
+		status = 'active' and role <> 'admin' and anonymized_at is null
+		and created_at >= $1 and created_at < $2
+		and ($3 = '' or first_name || ' ' || last_name ilike $3)`
+
+	var count int
+	if dryRun {
+		err := s.db.QueryRowContext(
+			ctx,
+			"select count(*) from accounts where "+where,
+			filter.CreatedFrom,
+			filter.CreatedTo,
+			filter.NamePattern,
+		).Scan(&count)
+		return count, err
+	}
+
+	query := `
+		with frozen as (
+			update accounts set status = 'frozen'
+			where ` + where + `
+			returning id, number
+		), logged as (
+			insert into audit_log (account_id, actor_id, action, outcome, ip, created_at)
+			select id, $4::int, $5::varchar, $6::varchar, $7::varchar, $8::timestamp from frozen
+		), queued as (
+			insert into webhook_outbox (event_type, payload, next_attempt_at, created_at)
+			select $9::varchar, jsonb_build_object('account_id', id, 'number', number, 'actor_id', $4::int), $8::timestamp, $8::timestamp
+			from frozen
+			where $10::boolean
+		)
+		select count(*) from frozen;`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		filter.CreatedFrom,
+		filter.CreatedTo,
+		filter.NamePattern,
+		event.ActorID,
+		event.Action,
+		event.Outcome,
+		event.IP,
+		event.CreatedAt,
+		WebhookEventAccountFrozen,
+		s.recordWebhooks,
+	).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) UpdatePassword(ctx context.Context, id int64, encryptedPassword string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update accounts set encrypted_password = $1 where id = $2", encryptedPassword, id)
+	return err
+}
+
+// ChangePassword replaces the account's password, moving the old hash into
+// the password history, of which only the newest keep entries are retained.
+func (s *PostgresStore) ChangePassword(ctx context.Context, id int64, encryptedPassword string, keep int) error {
+	if keep < 0 {
+		keep = 0
+	}
+	return s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if keep > 0 {
+			query := `
+				insert into password_history (account_id, encrypted_password, created_at)
+				select id, encrypted_password, $2 from accounts where id = $1;`
+			if _, err := tx.ExecContext(ctx, query, id, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			delete from password_history
+			where account_id = $1 and id not in (
+				select id from password_history
+				where account_id = $1
+				order by created_at desc, id desc
+				limit $2
+			);`
+		if _, err := tx.ExecContext(ctx, query, id, keep); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "update accounts set encrypted_password = $1 where id = $2", encryptedPassword, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+		}
+		return nil
+	})
+}
+
+// GetPasswordHistory returns the account's previous password hashes,
+// newest first.
+func (s *PostgresStore) GetPasswordHistory(ctx context.Context, id int64, limit int) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select encrypted_password
+		from password_history
+		where account_id = $1
+		order by created_at desc, id desc
+		limit $2;`
+
+	rows, err := s.db.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// Transfer moves amount from one account to another as a balanced posting
+// and, when webhooks are enabled, queues the transfer.completed event in
+// the outbox within the same transaction, so a committed transfer is never
+// left without its event. It returns nil when the destination doesn't
+// exist.
+func (s *PostgresStore) Transfer(ctx context.Context, fromNumber, toNumber string, amount Money, category string, metadata Metadata) (*TransferResult, error) {
+	var result *TransferResult
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		fromID, err := lookupAccountID(ctx, tx, fromNumber)
+		if err != nil {
+			return err
+		}
+		toID, err := lookupAccountID(ctx, tx, toNumber)
+		if errors.Is(err, ErrAccountNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkWhitelisted(ctx, tx, fromID, toNumber); err != nil {
+			return err
+		}
+		if err := checkNotSystem(ctx, tx, fromID, toID); err != nil {
+			return err
+		}
+
+		debit := &LedgerEntry{AccountID: &fromID, Amount: -amount, Kind: LedgerKindTransfer, Category: category, Metadata: metadata}
+		credit := &LedgerEntry{AccountID: &toID, Amount: amount, Kind: LedgerKindTransfer, Category: category, Metadata: metadata}
+		if err := postEntries(ctx, tx, []*LedgerEntry{debit, credit}); err != nil {
+			return err
+		}
+
+		var balance Money
+		if err := tx.QueryRowContext(ctx, "select balance from accounts where id = $1", fromID).Scan(&balance); err != nil {
+			return err
+		}
+		result = &TransferResult{
+			TransactionID: debit.TransactionID,
+			From:          fromNumber,
+			To:            toNumber,
+			Amount:        amount,
+			Category:      category,
+			Balance:       balance,
+			CreatedAt:     debit.CreatedAt,
+		}
+
+		if !s.recordWebhooks {
+			return nil
+		}
+		return insertOutboxMessage(ctx, tx, WebhookEventTransferCompleted, map[string]any{
+			"account_id":     toID,
+			"transaction_id": debit.TransactionID,
+			"from":           fromNumber,
+			"to":             toNumber,
+			"amount":         amount,
+			"category":       category,
+			"metadata":       metadata,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Sweep moves the whole balance of the fromID account to toNumber and
+// returns the amount moved. Both accounts are locked before the balance is
+// read, so deposits or transfers racing with the sweep either land first
+// and are swept along, or wait and land on the emptied account.
+func (s *PostgresStore) Sweep(ctx context.Context, fromID int64, toNumber string) (Money, error) {
+	var amount Money
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		toID, err := lookupAccountID(ctx, tx, toNumber)
+		if err != nil {
+			return err
+		}
+		if toID == fromID {
+			return fmt.Errorf("cannot sweep into the same account")
+		}
+		if err := checkWhitelisted(ctx, tx, fromID, toNumber); err != nil {
+			return err
+		}
+		if err := checkNotSystem(ctx, tx, fromID, toID); err != nil {
+			return err
+		}
+
+		ids := []int64{fromID, toID}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		var fromNumber string
+		for _, id := range ids {
+			var number string
+			var balance Money
+			err := tx.QueryRowContext(ctx, "select number, balance from accounts where id = $1 for update", id).Scan(&number, &balance)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+			}
+			if err != nil {
+				return err
+			}
+			if id == fromID {
+				fromNumber, amount = number, balance
+			}
+		}
+		if amount <= 0 {
+			return ErrInsufficientFunds
+		}
+
+		err = postEntries(ctx, tx, []*LedgerEntry{
+			{AccountID: &fromID, Amount: -amount, Kind: LedgerKindTransfer},
+			{AccountID: &toID, Amount: amount, Kind: LedgerKindTransfer},
+		})
+		if err != nil {
+			return err
+		}
+
+		if !s.recordWebhooks {
+			return nil
+		}
+		return insertOutboxMessage(ctx, tx, WebhookEventTransferCompleted, map[string]any{
+			"account_id": toID,
+			"from":       fromNumber,
+			"to":         toNumber,
+			"amount":     amount,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+// PostEntries writes a balanced set of ledger lines and applies them to the
+// account balances in one transaction. It is the only way balances change.
+func (s *PostgresStore) PostEntries(ctx context.Context, entries []*LedgerEntry) error {
+	return s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return postEntries(ctx, tx, entries)
+	})
+}
+
+// PostInterest credits amount of interest to the account for period. The
+// period is claimed in interest_accruals in the same transaction, so asking
+// twice for the same account and period posts once and returns false the
+// second time.
+func (s *PostgresStore) PostInterest(ctx context.Context, accountID int64, period string, amount Money) (bool, error) {
+	posted := false
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			insert into interest_accruals (account_id, period, amount, created_at)
+			values($1, $2, $3, $4)
+			on conflict (account_id, period) do nothing;`
+
+		res, err := tx.ExecContext(ctx, query, accountID, period, amount, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		posted = true
+		return postEntries(ctx, tx, []*LedgerEntry{
+			{AccountID: &accountID, Amount: amount, Kind: LedgerKindInterest},
+			{AccountID: nil, Amount: -amount, Kind: LedgerKindInterest},
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	return posted, nil
+}
+
+// SnapshotBalances records the balance of every open account as of takenAt
+// and returns how many were recorded. A second snapshot at the same moment
+// is ignored.
+//
+// The balance is the account's previous ledger snapshot plus the lines
+// created between the two, rather than the live balance, so that it is
+// exactly what GetBalanceAsOf would get by replaying the ledger and can
+// stand in for that replay. That only holds once every transaction that
+// may post a line dated before takenAt has committed, which is why the
+// job snapshots moments a settling delay in the past.
+func (s *PostgresStore) SnapshotBalances(ctx context.Context, takenAt time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		insert into balance_snapshots (account_id, balance, taken_at, from_ledger)
+		select a.id, coalesce(prev.balance, 0) + (
+			select coalesce(sum(e.amount), 0)
+			from ledger_entries e
+			where e.account_id = a.id and e.created_at < $1
+			and (prev.taken_at is null or e.created_at >= prev.taken_at)
+		), $1, true
+		from accounts a
+		left join lateral (
+			select balance, taken_at
+			from balance_snapshots
+			where account_id = a.id and from_ledger and taken_at < $1
+			order by taken_at desc
+			limit 1
+		) prev on true
+		where a.anonymized_at is null and a.created_at < $1
+		on conflict do nothing;`
+
+	res, err := s.db.ExecContext(ctx, query, takenAt)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetBalanceSnapshots pages through the account's snapshots taken in the
+// half-open range [from, to), oldest first.
+func (s *PostgresStore) GetBalanceSnapshots(ctx context.Context, accountID int64, from, to time.Time, limit, offset int) ([]*BalanceSnapshot, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	var total int
+	err := db.QueryRowContext(
+		ctx,
+		"select count(*) from balance_snapshots where account_id = $1 and taken_at >= $2 and taken_at < $3",
+		accountID,
+		from,
+		to,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		select account_id, balance, taken_at
+		from balance_snapshots
+		where account_id = $1 and taken_at >= $2 and taken_at < $3
+		order by taken_at
+		limit $4 offset $5;`
+
+	rows, err := db.QueryContext(ctx, query, accountID, from, to, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	snapshots := []*BalanceSnapshot{}
+	for rows.Next() {
+		snapshot := &BalanceSnapshot{}
+		if err := rows.Scan(&snapshot.AccountID, &snapshot.Balance, &snapshot.TakenAt); err != nil {
+			return nil, 0, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, total, rows.Err()
+}
+
+// GetBalanceAsOf sums the account's ledger lines created before the given
+// moment, which is zero for an account without activity by then. It starts
+// from the latest ledger snapshot at or before that moment, when there is
+// one, and only replays the lines created since.
+func (s *PostgresStore) GetBalanceAsOf(ctx context.Context, accountID int64, before time.Time) (Money, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		with snapshot as (
+			select balance, taken_at
+			from balance_snapshots
+			where account_id = $1 and from_ledger and taken_at <= $2
+			order by taken_at desc
+			limit 1
+		)
+		select coalesce((select balance from snapshot), 0) + coalesce(sum(e.amount), 0)
+		from ledger_entries e
+		where e.account_id = $1 and e.created_at < $2
+		and not exists (select 1 from snapshot where e.created_at < snapshot.taken_at);`
+
+	var balance Money
+	err := s.reader().QueryRowContext(ctx, query, accountID, before).Scan(&balance)
+	return balance, err
+}
+
+// GetTransactions pages through the account's ledger lines that pass the
+// filter, newest first. Statements are built from it too, so they always
+// agree with the listing.
+func (s *PostgresStore) GetTransactions(ctx context.Context, accountID int64, filter TransactionFilter, limit, offset int) ([]*LedgerEntry, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	where := `
+		account_id = $1 and created_at >= $2 and created_at < $3
+		and ($4::jsonb is null or metadata @> $4::jsonb)
+		and ($5 = '' or category = $5)`
+
+	query := `
+		select id, transaction_id, account_id, amount, kind, category, metadata, created_at, count(*) over ()
+		from ledger_entries
+		where ` + where + `
+		order by created_at desc, id desc
+		limit $6 offset $7;`
+
+	rows, err := db.QueryContext(ctx, query, accountID, filter.From, filter.To, filter.Metadata, filter.Category, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*LedgerEntry{}
+	total := 0
+	for rows.Next() {
+		entry, err := scanIntoLedgerEntry(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	total, err = pageTotal(ctx, db, total, len(entries) > 0 || offset == 0,
+		"select count(*) from ledger_entries where "+where, accountID, filter.From, filter.To, filter.Metadata, filter.Category)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// GetAllTransactions pages through the ledger lines of every account,
+// newest first, including the outside-world side of deposits.
+func (s *PostgresStore) GetAllTransactions(ctx context.Context, filter TransactionFilter, limit, offset int) ([]*LedgerEntry, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	db := s.reader()
+
+	where := `
+		created_at >= $1 and created_at < $2
+		and ($3::jsonb is null or metadata @> $3::jsonb)
+		and ($4 = '' or category = $4)`
+
+	query := `
+		select id, transaction_id, account_id, amount, kind, category, metadata, created_at, count(*) over ()
+		from ledger_entries
+		where ` + where + `
+		order by created_at desc, id desc
+		limit $5 offset $6;`
+
+	rows, err := db.QueryContext(ctx, query, filter.From, filter.To, filter.Metadata, filter.Category, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*LedgerEntry{}
+	total := 0
+	for rows.Next() {
+		entry, err := scanIntoLedgerEntry(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	total, err = pageTotal(ctx, db, total, len(entries) > 0 || offset == 0,
+		"select count(*) from ledger_entries where "+where, filter.From, filter.To, filter.Metadata, filter.Category)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// GetCategoryTotals sums the account's ledger lines created in [from, to)
+// per category, in category order.
+func (s *PostgresStore) GetCategoryTotals(ctx context.Context, accountID int64, from, to time.Time) ([]*CategoryTotal, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select category, sum(amount), count(*)
+		from ledger_entries
+		where account_id = $1 and created_at >= $2 and created_at < $3
+		group by category
+		order by category;`
+
+	rows, err := s.reader().QueryContext(ctx, query, accountID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []*CategoryTotal{}
+	for rows.Next() {
+		t := &CategoryTotal{}
+		if err := rows.Scan(&t.Category, &t.Total, &t.Count); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// GetFlowTotals sums the account's incoming and outgoing ledger lines
+// created in [from, to), both as positive amounts.
+func (s *PostgresStore) GetFlowTotals(ctx context.Context, accountID int64, from, to time.Time) (Money, Money, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select
+			coalesce(sum(amount) filter (where amount > 0), 0),
+			coalesce(sum(-amount) filter (where amount < 0), 0)
+		from ledger_entries
+		where account_id = $1 and created_at >= $2 and created_at < $3;`
+
+	var inflows, outflows Money
+	err := s.reader().QueryRowContext(ctx, query, accountID, from, to).Scan(&inflows, &outflows)
+	return inflows, outflows, err
+}
+
+// VerifyLedger replays the account's ledger lines and compares their sum
+// with the stored balance. With a non-nil repair a drifted balance is set
+// to the ledger's, and repair, filled in with the account, is written to
+// the audit log in the same transaction. The account row stays locked
+// throughout, so no posting can land between the check and the repair.
+func (s *PostgresStore) VerifyLedger(ctx context.Context, id int64, repair *AuditEvent) (*LedgerVerification, error) {
+	v := &LedgerVerification{}
+	err := s.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			"select id, number, balance from accounts where id = $1 and anonymized_at is null for update", id,
+		).Scan(&v.AccountID, &v.Number, &v.StoredBalance)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, "select amount from ledger_entries where account_id = $1 order by created_at, id", id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var amount Money
+			if err := rows.Scan(&amount); err != nil {
+				return err
+			}
+			if v.LedgerBalance, err = v.LedgerBalance.Add(amount); err != nil {
+				return err
+			}
+			v.Entries++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if v.Difference, err = v.StoredBalance.Sub(v.LedgerBalance); err != nil {
+			return err
+		}
+		v.Matches = v.Difference == 0
+		if v.Matches || repair == nil {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, "update accounts set balance = $2 where id = $1", id, v.LedgerBalance); err != nil {
+			return err
+		}
+		repair.AccountID = id
+		if err := insertAuditEvent(ctx, tx, repair); err != nil {
+			return err
+		}
+		v.Repaired = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// GetBalanceMismatches compares every account's stored balance with the
+// sum of its ledger lines and returns the ones that differ.
+func (s *PostgresStore) GetBalanceMismatches(ctx context.Context) ([]*BalanceMismatch, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select a.id, a.number, a.balance, coalesce(sum(l.amount), 0)
+		from accounts a
+		left join ledger_entries l on l.account_id = a.id
+		group by a.id
+		having a.balance <> coalesce(sum(l.amount), 0)
+		order by a.id;`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mismatches := []*BalanceMismatch{}
+	for rows.Next() {
+		m := &BalanceMismatch{}
+		if err := rows.Scan(&m.AccountID, &m.Number, &m.StoredBalance, &m.LedgerBalance); err != nil {
+			return nil, err
+		}
+		if m.Difference, err = m.StoredBalance.Sub(m.LedgerBalance); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches, rows.Err()
+}
+
+// ClaimPendingWebhooks returns outbox messages that have neither been
+// delivered nor given up on and whose next attempt is due, oldest first,
+// and claims them for lease by pushing their next attempt that far out.
+// Dispatchers polling at the same time skip the rows another one is
+// claiming, so each message goes to one of them; a message whose
+// dispatcher dies before marking it comes due again when the lease ends.
+func (s *PostgresStore) ClaimPendingWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		update webhook_outbox set next_attempt_at = $2
+		where id in (
+			select id
+			from webhook_outbox
+			where status = 'pending' and next_attempt_at <= $1
+			order by id
+			limit $3
+			for update skip locked
+		)
+		returning id, event_type, payload, attempts, next_attempt_at, created_at;`
+
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*OutboxMessage{}
+	for rows.Next() {
+		msg := &OutboxMessage{}
+		err := rows.Scan(
+			&msg.ID,
+			&msg.EventType,
+			&msg.Payload,
+			&msg.Attempts,
+			&msg.NextAttemptAt,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// returning gives no order guarantee.
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+func (s *PostgresStore) MarkWebhookSent(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		"update webhook_outbox set attempts = attempts + 1, sent_at = $1, status = 'sent' where id = $2",
+		time.Now().UTC(),
+		id,
+	)
+	return err
+}
+
+// MarkWebhookRetry records a failed delivery attempt and when to try again.
+func (s *PostgresStore) MarkWebhookRetry(ctx context.Context, id int64, nextAttemptAt time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		"update webhook_outbox set attempts = attempts + 1, next_attempt_at = $1 where id = $2",
+		nextAttemptAt,
+		id,
+	)
+	return err
+}
+
+// MarkWebhookFailed records a last failed delivery attempt and stops
+// retrying the message.
+func (s *PostgresStore) MarkWebhookFailed(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update webhook_outbox set attempts = attempts + 1, status = 'failed' where id = $1", id)
+	return err
+}
+
+func (s *PostgresStore) CreateAuditEvent(ctx context.Context, event *AuditEvent) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		insert into audit_log (account_id, actor_id, action, outcome, ip, geo, created_at)
+		values($1, $2, $3, $4, $5, $6, $7)
+		returning id;`
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		event.AccountID,
+		event.ActorID,
+		event.Action,
+		event.Outcome,
+		event.IP,
+		event.Geo,
+		event.CreatedAt,
+	).Scan(&event.ID)
+}
+
+// CountLoginFailuresSince counts failed logins after since that happened
+// after the account's most recent successful login.
+func (s *PostgresStore) CountLoginFailuresSince(ctx context.Context, accountID int64, since time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select count(*)
+		from audit_log
+		where account_id = $1 and action = $2 and outcome = $3 and created_at >= $4
+		and created_at > coalesce((
+			select max(created_at)
+			from audit_log
+			where account_id = $1 and action = $2 and outcome = $5
+		), '-infinity');`
+
+	var count int
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		accountID,
+		AuditActionLogin,
+		AuditOutcomeFailure,
+		since,
+		AuditOutcomeSuccess,
+	).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) GetLoginHistory(ctx context.Context, accountID int64, limit, offset int) ([]*AuditEvent, int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var total int
+	err := s.db.QueryRowContext(
+		ctx,
+		"select count(*) from audit_log where account_id = $1 and action = $2",
+		accountID,
+		AuditActionLogin,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		select id, account_id, action, outcome, ip, geo, created_at
+		from audit_log
+		where account_id = $1 and action = $2
+		order by created_at desc
+		limit $3 offset $4;`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID, AuditActionLogin, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		event := &AuditEvent{}
+		var geo []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.AccountID,
+			&event.Action,
+			&event.Outcome,
+			&event.IP,
+			&geo,
+			&event.CreatedAt,
+		)
+		if err == nil && geo != nil {
+			event.Geo = &GeoLocation{}
+			err = event.Geo.Scan(geo)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+	return events, total, rows.Err()
+}
+
+// CreateBeneficiary saves a recipient for the account, failing with
+// ErrBeneficiaryExists when the number is already saved.
+func (s *PostgresStore) CreateBeneficiary(ctx context.Context, b *Beneficiary) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		insert into beneficiaries (account_id, nickname, number, created_at)
+		values($1, $2, $3, $4)
+		on conflict (account_id, number) do nothing
+		returning id;`
+
+	err := s.db.QueryRowContext(ctx, query, b.AccountID, b.Nickname, b.Number, b.CreatedAt).Scan(&b.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrBeneficiaryExists
+	}
+	return err
+}
+
+func (s *PostgresStore) GetBeneficiaries(ctx context.Context, accountID int64) ([]*Beneficiary, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select id, account_id, nickname, number, created_at
+		from beneficiaries
+		where account_id = $1
+		order by nickname, id;`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	beneficiaries := []*Beneficiary{}
+	for rows.Next() {
+		b, err := scanIntoBeneficiary(rows)
+		if err != nil {
+			return nil, err
+		}
+		beneficiaries = append(beneficiaries, b)
+	}
+	return beneficiaries, rows.Err()
+}
+
+// GetBeneficiary returns one of the account's saved recipients. Ids of
+// other accounts' beneficiaries are reported as not found.
+func (s *PostgresStore) GetBeneficiary(ctx context.Context, accountID, id int64) (*Beneficiary, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select id, account_id, nickname, number, created_at
+		from beneficiaries
+		where account_id = $1 and id = $2;`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoBeneficiary(rows)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrBeneficiaryNotFound
+}
+
+func (s *PostgresStore) DeleteBeneficiary(ctx context.Context, accountID, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "delete from beneficiaries where account_id = $1 and id = $2", accountID, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBeneficiaryNotFound
+	}
+	return nil
+}
+
+// GetTransferWhitelist returns whether the account is restricted to its
+// whitelist and the entries on it, oldest first.
+func (s *PostgresStore) GetTransferWhitelist(ctx context.Context, accountID int64) (*TransferWhitelist, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	whitelist := &TransferWhitelist{Entries: []*WhitelistEntry{}}
+	err := s.db.QueryRowContext(
+		ctx,
+		"select whitelist_enabled from accounts where id = $1 and anonymized_at is null",
+		accountID,
+	).Scan(&whitelist.Enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("account %d %w", accountID, ErrAccountNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		select id, account_id, number, created_at
+		from transfer_whitelist
+		where account_id = $1
+		order by created_at, id;`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &WhitelistEntry{}
+		if err := rows.Scan(&entry.ID, &entry.AccountID, &entry.Number, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		whitelist.Entries = append(whitelist.Entries, entry)
+	}
+	return whitelist, rows.Err()
+}
+
+func (s *PostgresStore) SetTransferWhitelistEnabled(ctx context.Context, accountID int64, enabled bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		"update accounts set whitelist_enabled = $2 where id = $1 and anonymized_at is null",
+		accountID,
+		enabled,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("account %d %w", accountID, ErrAccountNotFound)
+	}
+	return nil
 }
 
-type PostgresStore struct {
-	db *sql.DB
+// AddWhitelistEntry whitelists a destination for the account, failing with
+// ErrWhitelistEntryExists when it already is.
+func (s *PostgresStore) AddWhitelistEntry(ctx context.Context, entry *WhitelistEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		insert into transfer_whitelist (account_id, number, created_at)
+		values($1, $2, $3)
+		on conflict (account_id, number) do nothing
+		returning id;`
+
+	err := s.db.QueryRowContext(ctx, query, entry.AccountID, entry.Number, entry.CreatedAt).Scan(&entry.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWhitelistEntryExists
+	}
+	return err
 }
 
-func NewPostgresStore() (*PostgresStore, error) {
-	godotenv.Load(".env")
-	connStr := os.Getenv("POSTGRES_URL")
+func (s *PostgresStore) DeleteWhitelistEntry(ctx context.Context, accountID int64, number string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	db, err := sql.Open("postgres", connStr)
+	res, err := s.db.ExecContext(ctx, "delete from transfer_whitelist where account_id = $1 and number = $2", accountID, number)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWhitelistEntryNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		insert into api_keys (account_id, name, key_hash, scopes, created_at)
+		values($1, $2, $3, $4, $5)
+		returning id;`
+
+	return s.db.QueryRowContext(ctx, query, k.AccountID, k.Name, k.KeyHash, pq.Array(k.Scopes), k.CreatedAt).Scan(&k.ID)
+}
+
+// GetAPIKeyByHash finds the unrevoked key with the given hash. It reads
+// the primary so a revocation takes effect at once.
+func (s *PostgresStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select id, account_id, name, key_hash, scopes, created_at, revoked_at
+		from api_keys
+		where key_hash = $1 and revoked_at is null;`
+
+	rows, err := s.db.QueryContext(ctx, query, hash)
 	if err != nil {
 		return nil, err
 	}
-	if err = db.Ping(); err != nil {
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAPIKey(rows)
+	}
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return &PostgresStore{
-		db: db,
-	}, nil
+	return nil, ErrAPIKeyNotFound
 }
 
-func (s *PostgresStore) GetAccounts() ([]*Account, error) {
-	rows, err := s.db.Query("select * from accounts")
+// GetAPIKeys lists the account's keys, revoked ones included, newest
+// first.
+func (s *PostgresStore) GetAPIKeys(ctx context.Context, accountID int64) ([]*APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select id, account_id, name, key_hash, scopes, created_at, revoked_at
+		from api_keys
+		where account_id = $1
+		order by created_at desc, id desc;`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	accounts := []*Account{}
+	keys := []*APIKey{}
 	for rows.Next() {
-		acc, err := scanIntoAccount(rows)
+		k, err := scanIntoAPIKey(rows)
 		if err != nil {
 			return nil, err
 		}
-		accounts = append(accounts, acc)
+		keys = append(keys, k)
 	}
-	return accounts, nil
+	return keys, rows.Err()
 }
 
-func (s *PostgresStore) GetAccountByID(id int) (*Account, error) {
-	rows, err := s.db.Query("select * from accounts where id = $1", id)
+// RevokeAPIKey revokes one of the account's keys. Keys are kept after
+// revocation so the listing shows what existed.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, accountID, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		"update api_keys set revoked_at = $3 where account_id = $1 and id = $2 and revoked_at is null",
+		accountID,
+		id,
+		time.Now().UTC(),
+	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	for rows.Next() {
-		return scanIntoAccount(rows)
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
 	}
-	return nil, fmt.Errorf("account %d not found", id)
+	return nil
+}
+
+// GetTokenEpoch returns the moment before which every issued JWT is void.
+func (s *PostgresStore) GetTokenEpoch(ctx context.Context) (time.Time, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var epoch time.Time
+	err := s.db.QueryRowContext(ctx, "select not_before from token_epoch").Scan(&epoch)
+	return epoch, err
 }
 
-func (s *PostgresStore) GetAccountByNumber(number string) (*Account, error) {
-	rows, err := s.db.Query("select * from accounts where number = $1", number)
+// BumpTokenEpoch moves the token epoch to now, revoking every JWT issued
+// so far, and returns the new epoch.
+func (s *PostgresStore) BumpTokenEpoch(ctx context.Context) (time.Time, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var epoch time.Time
+	err := s.db.QueryRowContext(
+		ctx,
+		"update token_epoch set not_before = $1 returning not_before",
+		time.Now().UTC(),
+	).Scan(&epoch)
+	return epoch, err
+}
+
+// withTx runs fn inside a transaction, committing when it returns nil and
+// rolling back otherwise. The query timeout covers the whole transaction:
+// fn is handed the context carrying it and must run its statements with
+// that one.
+func (s *PostgresStore) withTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer tx.Rollback()
 
-	for rows.Next() {
-		return scanIntoAccount(rows)
+	start := time.Now()
+	defer func() {
+		if elapsed := time.Since(start); s.db.slowQuery > 0 && elapsed >= s.db.slowQuery {
+			log.Printf("slow transaction took %s", elapsed)
+		}
+	}()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// logSlowQuery logs query when it took at least threshold. Only the number
+// of arguments is logged, never their values, which can be passwords or
+// personal data. A zero threshold disables the log.
+func logSlowQuery(query string, nargs int, elapsed, threshold time.Duration) {
+	if threshold <= 0 || elapsed < threshold {
+		return
 	}
-	return nil, fmt.Errorf("account %s not found", number)
+	log.Printf("slow query took %s: %s (%d args)", elapsed, strings.Join(strings.Fields(query), " "), nargs)
 }
 
-func (s *PostgresStore) CreateAccount(acc *Account) error {
+func insertAccount(ctx context.Context, tx *sql.Tx, acc *Account) error {
 	query := `
-		insert into accounts (first_name, last_name, number, encrypted_password, balance, created_at)
-		values($1, $2, $3, $4, $5, $6);`
+		insert into accounts (first_name, last_name, number, encrypted_password, balance, role, account_type, status, created_at)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		returning id;`
 
-	_, err := s.db.Query(
+	return tx.QueryRowContext(
+		ctx,
 		query,
 		acc.FirstName,
 		acc.LastName,
 		acc.Number,
 		acc.EncryptedPassword,
 		acc.Balance,
+		acc.Role,
+		acc.Type,
+		acc.Status,
 		acc.CreatedAt,
-	)
+	).Scan(&acc.ID)
+}
 
-	if err != nil {
+// checkWhitelisted fails with ErrNotWhitelisted when fromID is restricted
+// to its transfer whitelist and toNumber is not on it.
+func checkWhitelisted(ctx context.Context, tx *sql.Tx, fromID int64, toNumber string) error {
+	query := `
+		select not a.whitelist_enabled or exists (
+			select 1 from transfer_whitelist w where w.account_id = a.id and w.number = $2
+		)
+		from accounts a
+		where a.id = $1;`
+
+	var allowed bool
+	if err := tx.QueryRowContext(ctx, query, fromID, toNumber).Scan(&allowed); err != nil {
 		return err
 	}
+	if !allowed {
+		return ErrNotWhitelisted
+	}
+	return nil
+}
 
+// checkNotSystem fails with ErrSystemAccount when any of the accounts is a
+// system account. Only the user-facing Transfer and Sweep call it; the
+// bank's own postings to those accounts go through postEntries directly.
+func checkNotSystem(ctx context.Context, tx *sql.Tx, ids ...int64) error {
+	var system bool
+	err := tx.QueryRowContext(ctx,
+		"select exists (select 1 from accounts where id = any($1) and system)",
+		pq.Array(ids),
+	).Scan(&system)
+	if err != nil {
+		return err
+	}
+	if system {
+		return ErrSystemAccount
+	}
 	return nil
 }
 
-func (s *PostgresStore) DeleteAccount(id int) (int, error) {
-	rows, err := s.db.Query("delete from accounts where id = $1 returning id", id)
-	for rows.Next() {
-		var id int
-		err := rows.Scan(&id)
-		return id, err
+func lookupAccountID(ctx context.Context, tx *sql.Tx, number string) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, "select id from accounts where number = $1 and anonymized_at is null and status = 'active'", number).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("account %s %w", number, ErrAccountNotFound)
+	}
+	return id, err
+}
+
+// postEntries validates and writes one posting inside tx. The touched
+// accounts are locked in id order, so concurrent postings over the same
+// accounts queue up instead of deadlocking, and no account may end up with
+// a negative balance.
+func postEntries(ctx context.Context, tx *sql.Tx, entries []*LedgerEntry) error {
+	if err := validateEntries(entries); err != nil {
+		return err
+	}
+
+	balances := map[int64]Money{}
+	for _, entry := range entries {
+		if entry.AccountID != nil {
+			balances[*entry.AccountID] = 0
+		}
+	}
+	ids := make([]int64, 0, len(balances))
+	for id := range balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		var balance Money
+		err := tx.QueryRowContext(ctx, "select balance from accounts where id = $1 for update", id).Scan(&balance)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("account %d %w", id, ErrAccountNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		balances[id] = balance
+	}
+
+	for _, entry := range entries {
+		if entry.AccountID == nil {
+			continue
+		}
+		balance, err := balances[*entry.AccountID].Add(entry.Amount)
+		if err != nil {
+			return err
+		}
+		balances[*entry.AccountID] = balance
+	}
+	for _, id := range ids {
+		if balances[id] < 0 {
+			return ErrInsufficientFunds
+		}
+	}
+
+	transactionID := uuid.NewString()
+	now := NewTimestamp(time.Now())
+	for _, entry := range entries {
+		entry.TransactionID = transactionID
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+		if err := insertLedgerEntry(ctx, tx, entry); err != nil {
+			return err
+		}
 	}
-	return 0, err
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, "update accounts set balance = $1 where id = $2", balances[id], id); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *PostgresStore) Transfer(accountNumber string, amount float64) (int, error) {
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, event *AuditEvent) error {
 	query := `
-		update accounts
-		set balance = balance + $1
-		where number = $2
-		returning id;
-	`
-	rows, err := s.db.Query(query, amount, accountNumber)
-	for rows.Next() {
-		var id int
-		err := rows.Scan(&id)
-		return id, err
+		insert into audit_log (account_id, actor_id, action, outcome, ip, geo, created_at)
+		values($1, $2, $3, $4, $5, $6, $7)
+		returning id;`
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		event.AccountID,
+		event.ActorID,
+		event.Action,
+		event.Outcome,
+		event.IP,
+		event.Geo,
+		event.CreatedAt,
+	).Scan(&event.ID)
+}
+
+func insertLedgerEntry(ctx context.Context, tx *sql.Tx, entry *LedgerEntry) error {
+	query := `
+		insert into ledger_entries (transaction_id, account_id, amount, kind, category, metadata, created_at)
+		values($1, $2, $3, $4, $5, $6, $7)
+		returning id;`
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		entry.TransactionID,
+		entry.AccountID,
+		entry.Amount,
+		entry.Kind,
+		entry.Category,
+		entry.Metadata,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+}
+
+func insertOutboxMessage(ctx context.Context, tx *sql.Tx, eventType string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
 	}
-	return 0, err
+
+	now := time.Now().UTC()
+	query := `
+		insert into webhook_outbox (event_type, payload, next_attempt_at, created_at)
+		values($1, $2, $3, $4);`
+
+	_, err = tx.ExecContext(ctx, query, eventType, body, now, now)
+	return err
 }
 
 func (s *PostgresStore) Init() error {
-	return s.CreateAccountTable()
+	if err := s.CreateAccountTable(); err != nil {
+		return err
+	}
+	if err := s.CreateLedgerTable(); err != nil {
+		return err
+	}
+	if err := s.CreateAuditLogTable(); err != nil {
+		return err
+	}
+	if err := s.CreateWebhookOutboxTable(); err != nil {
+		return err
+	}
+	if err := s.CreatePasswordHistoryTable(); err != nil {
+		return err
+	}
+	if err := s.CreateInterestAccrualTable(); err != nil {
+		return err
+	}
+	if err := s.CreateTokenEpochTable(); err != nil {
+		return err
+	}
+	if err := s.CreateBeneficiaryTable(); err != nil {
+		return err
+	}
+	if err := s.CreateAPIKeyTable(); err != nil {
+		return err
+	}
+	if err := s.CreateTransferWhitelistTable(); err != nil {
+		return err
+	}
+	if err := s.CreateBalanceSnapshotTable(); err != nil {
+		return err
+	}
+	if err := s.CreateAccountIdempotencyKeyTable(); err != nil {
+		return err
+	}
+	return s.CreateIndexes()
 }
 
 func (s *PostgresStore) CreateAccountTable() error {
@@ -141,22 +2284,300 @@ func (s *PostgresStore) CreateAccountTable() error {
 			encrypted_password varchar(255),
 			balance int,
 			created_at timestamp
+		);
+		alter table accounts add column if not exists role varchar(32) not null default 'user';
+		alter table accounts add column if not exists account_type varchar(16) not null default 'checking';
+		alter table accounts add column if not exists anonymized_at timestamp;
+		alter table accounts add column if not exists status varchar(16) not null default 'active';
+		alter table accounts add column if not exists whitelist_enabled boolean not null default false;
+		alter table accounts add column if not exists system boolean not null default false;
+		alter table accounts add column if not exists purge_at timestamp;
+		alter table accounts add column if not exists status_before_close varchar(16);
+		alter table accounts alter column balance type bigint;
+		create sequence if not exists account_number_seq minvalue 0 start 0;`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateLedgerTable() error {
+	query := `
+		create table if not exists ledger_entries (
+			id serial not null primary key,
+			account_id int not null references accounts(id),
+			amount bigint not null,
+			kind varchar(32) not null,
+			created_at timestamp not null
+		);
+		alter table ledger_entries add column if not exists transaction_id uuid;
+		alter table ledger_entries alter column account_id drop not null;
+		alter table ledger_entries add column if not exists metadata jsonb;
+		alter table ledger_entries add column if not exists category varchar(32) not null default '';`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateAuditLogTable() error {
+	query := `
+		create table if not exists audit_log (
+			id serial not null primary key,
+			account_id int references accounts(id) on delete set null,
+			action varchar(64) not null,
+			outcome varchar(16) not null,
+			ip varchar(64),
+			created_at timestamp not null
+		);
+		alter table audit_log add column if not exists actor_id int references accounts(id) on delete set null;
+		alter table audit_log add column if not exists geo jsonb;`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateWebhookOutboxTable() error {
+	query := `
+		create table if not exists webhook_outbox (
+			id serial not null primary key,
+			event_type varchar(64) not null,
+			payload jsonb not null,
+			attempts int not null default 0,
+			next_attempt_at timestamp not null,
+			sent_at timestamp,
+			created_at timestamp not null
+		);
+		alter table webhook_outbox add column if not exists status varchar(16) not null default 'pending';
+		update webhook_outbox set status = 'sent' where sent_at is not null and status = 'pending';`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreatePasswordHistoryTable() error {
+	query := `
+		create table if not exists password_history (
+			id serial not null primary key,
+			account_id int not null references accounts(id) on delete cascade,
+			encrypted_password varchar(255) not null,
+			created_at timestamp not null
+		);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateInterestAccrualTable() error {
+	query := `
+		create table if not exists interest_accruals (
+			account_id int not null references accounts(id),
+			period varchar(16) not null,
+			amount bigint not null,
+			created_at timestamp not null,
+			primary key (account_id, period)
+		);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateTokenEpochTable holds the single token epoch row, starting at the
+// Unix epoch so no token is revoked until an admin bumps it.
+func (s *PostgresStore) CreateTokenEpochTable() error {
+	query := `
+		create table if not exists token_epoch (
+			id boolean primary key default true check (id),
+			not_before timestamp not null
+		);
+		insert into token_epoch (not_before) values ('epoch') on conflict do nothing;`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateBeneficiaryTable() error {
+	query := `
+		create table if not exists beneficiaries (
+			id serial not null primary key,
+			account_id int not null references accounts(id) on delete cascade,
+			nickname varchar(64) not null,
+			number varchar(255) not null,
+			created_at timestamp not null,
+			unique (account_id, number)
+		);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateBalanceSnapshotTable() error {
+	query := `
+		create table if not exists balance_snapshots (
+			account_id int not null references accounts(id) on delete cascade,
+			balance bigint not null,
+			taken_at timestamp not null,
+			primary key (account_id, taken_at)
+		);
+		alter table balance_snapshots add column if not exists from_ledger boolean not null default false;`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateTransferWhitelistTable() error {
+	query := `
+		create table if not exists transfer_whitelist (
+			id serial not null primary key,
+			account_id int not null references accounts(id) on delete cascade,
+			number varchar(255) not null,
+			created_at timestamp not null,
+			unique (account_id, number)
+		);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateAPIKeyTable() error {
+	query := `
+		create table if not exists api_keys (
+			id serial not null primary key,
+			account_id int not null references accounts(id) on delete cascade,
+			name varchar(64) not null,
+			key_hash varchar(64) not null unique,
+			scopes text[] not null,
+			created_at timestamp not null,
+			revoked_at timestamp
 		);`
 
 	_, err := s.db.Exec(query)
 	return err
 }
 
-func scanIntoAccount(rows *sql.Rows) (*Account, error) {
+func (s *PostgresStore) CreateAccountIdempotencyKeyTable() error {
+	query := `
+		create table if not exists account_idempotency_keys (
+			key varchar(255) not null primary key,
+			account_id int not null references accounts(id) on delete cascade,
+			created_at timestamp not null
+		);
+		create index if not exists account_idempotency_keys_created_at_idx on account_idempotency_keys (created_at);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateIndexes adds the indexes behind the lookups the API runs on every
+// request, so they stay index scans as the tables grow.
+func (s *PostgresStore) CreateIndexes() error {
+	query := `
+		-- login, JWT auth and transfers all look accounts up by number; being
+		-- unique also closes the race between generating a number and
+		-- inserting it
+		create unique index if not exists accounts_number_idx on accounts (number);
+
+		-- the admin date-range listing filters and orders by creation time
+		create index if not exists accounts_created_at_idx on accounts (created_at);
+
+		-- transaction listings and per-account ledger sums filter by account
+		-- and page newest first
+		create index if not exists ledger_entries_account_created_at_idx on ledger_entries (account_id, created_at);
+
+		-- the admin transaction feed pages every account's lines newest first
+		create index if not exists ledger_entries_created_at_idx on ledger_entries (created_at);
+
+		-- login history is read per account, newest first
+		create index if not exists audit_log_account_action_created_at_idx on audit_log (account_id, action, created_at);`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// scanIntoAccount reads accountColumns, followed by any extra columns the
+// query selected into extra.
+func scanIntoAccount(rows *sql.Rows, extra ...any) (*Account, error) {
 	acc := &Account{}
-	err := rows.Scan(
+	err := rows.Scan(append([]any{
 		&acc.ID,
 		&acc.FirstName,
 		&acc.LastName,
 		&acc.Number,
 		&acc.EncryptedPassword,
 		&acc.Balance,
+		&acc.Role,
+		&acc.Type,
+		&acc.Status,
 		&acc.CreatedAt,
-	)
+		&acc.PurgeAt,
+	}, extra...)...)
 	return acc, err
 }
+
+func scanIntoBeneficiary(rows *sql.Rows) (*Beneficiary, error) {
+	b := &Beneficiary{}
+	err := rows.Scan(
+		&b.ID,
+		&b.AccountID,
+		&b.Nickname,
+		&b.Number,
+		&b.CreatedAt,
+	)
+	return b, err
+}
+
+func scanIntoLedgerEntry(rows *sql.Rows, extra ...any) (*LedgerEntry, error) {
+	entry := &LedgerEntry{}
+	err := rows.Scan(append([]any{
+		&entry.ID,
+		&entry.TransactionID,
+		&entry.AccountID,
+		&entry.Amount,
+		&entry.Kind,
+		&entry.Category,
+		&entry.Metadata,
+		&entry.CreatedAt,
+	}, extra...)...)
+	return entry, err
+}
+
+func scanIntoAPIKey(rows *sql.Rows) (*APIKey, error) {
+	k := &APIKey{}
+	err := rows.Scan(
+		&k.ID,
+		&k.AccountID,
+		&k.Name,
+		&k.KeyHash,
+		pq.Array(&k.Scopes),
+		&k.CreatedAt,
+		&k.RevokedAt,
+	)
+	return k, err
+}
+
+// PoolStats is the connection pool of one database, from sql.DBStats.
+type PoolStats struct {
+	Database          string `json:"database"`
+	MaxOpen           int    `json:"max_open"`
+	Open              int    `json:"open"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDurationMs    int64  `json:"wait_duration_ms"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+func newPoolStats(database string, s sql.DBStats) *PoolStats {
+	return &PoolStats{
+		Database:          database,
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDurationMs:    s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testDatabaseEnv names the Postgres database the store tests run against.
+// They are skipped when it is unset. Each test works in a schema of its
+// own, dropped afterwards, so the database can be shared.
+const testDatabaseEnv = "GOBANK_TEST_DATABASE_URL"
+
+func newTestStore(t testing.TB) *PostgresStore {
+	t.Helper()
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("gobank_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("create schema " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("drop schema " + schema + " cascade"); err != nil {
+			t.Error("dropping test schema:", err)
+		}
+		admin.Close()
+	})
+
+	store, err := NewPostgresStore(&Config{DatabaseURL: withSearchPath(connStr, schema)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// withSearchPath points connStr, in either URL or key=value form, at
+// schema. The driver passes parameters it does not know on to the server.
+func withSearchPath(connStr, schema string) string {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		sep := "?"
+		if strings.Contains(connStr, "?") {
+			sep = "&"
+		}
+		return connStr + sep + "search_path=" + url.QueryEscape(schema)
+	}
+	return connStr + " search_path=" + schema
+}
+
+func newTestAccount(t testing.TB, store Storage, opening Money) *Account {
+	t.Helper()
+	acc, err := NewAccount(context.Background(), "Ada", "Lovelace", "correct horse battery staple", uuid.NewString(), Pepper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Balance = opening
+	if err := store.CreateAccount(context.Background(), acc); err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+// anonymizedRow reads back what anonymizing leaves of the account's row.
+func anonymizedRow(t *testing.T, store *PostgresStore, id int64) (name string, anonymized bool) {
+	t.Helper()
+	var first, last, password string
+	err := store.db.QueryRow(
+		"select first_name, last_name, encrypted_password, anonymized_at is not null from accounts where id = $1", id,
+	).Scan(&first, &last, &password, &anonymized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return first + last + password, anonymized
+}
+
+func TestAnonymizeAccount(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	acc := newTestAccount(t, store, 0)
+
+	if err := store.ChangePassword(ctx, acc.ID, acc.EncryptedPassword, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.AnonymizeAccount(ctx, int(acc.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != int(acc.ID) {
+		t.Fatalf("AnonymizeAccount returned %d, want %d", id, acc.ID)
+	}
+
+	if name, anonymized := anonymizedRow(t, store, acc.ID); name != "" || !anonymized {
+		t.Errorf("row left with personal data %q, anonymized %v", name, anonymized)
+	}
+	var history int
+	if err := store.db.QueryRow("select count(*) from password_history where account_id = $1", acc.ID).Scan(&history); err != nil {
+		t.Fatal(err)
+	}
+	if history != 0 {
+		t.Errorf("%d password history rows left", history)
+	}
+	if _, err := store.GetAccountByID(ctx, int(acc.ID)); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("GetAccountByID after anonymizing: got %v, want ErrAccountNotFound", err)
+	}
+
+	id, err = store.AnonymizeAccount(ctx, int(acc.ID))
+	if err != nil || id != 0 {
+		t.Errorf("anonymizing twice: got %d, %v, want 0, nil", id, err)
+	}
+}
+
+func TestAnonymizeAccountWithFunds(t *testing.T) {
+	store := newTestStore(t)
+	acc := newTestAccount(t, store, 100)
+
+	if _, err := store.AnonymizeAccount(context.Background(), int(acc.ID)); !errors.Is(err, ErrAccountHasFunds) {
+		t.Fatalf("got %v, want ErrAccountHasFunds", err)
+	}
+	if name, anonymized := anonymizedRow(t, store, acc.ID); name == "" || anonymized {
+		t.Errorf("account with funds was anonymized")
+	}
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	store := newTestStore(t)
+	acc := newTestAccount(t, store, 0)
+
+	setJWTSecret("test-secret")
+	token, err := createJWT(acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/accounts/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+	if _, anonymized := anonymizedRow(t, store, acc.ID); !anonymized {
+		t.Error("account was not anonymized")
+	}
+}
+
+func TestAnonymizeAccountsBatch(t *testing.T) {
+	store := newTestStore(t)
+	a := newTestAccount(t, store, 0)
+	b := newTestAccount(t, store, 0)
+	missing := int(b.ID) + 1000
+
+	results, err := store.AnonymizeAccounts(context.Background(), []int{int(b.ID), int(a.ID), missing, int(a.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDeleteResults(t, results, []*DeleteResult{
+		{ID: int(b.ID), Result: DeleteResultDeleted},
+		{ID: int(a.ID), Result: DeleteResultDeleted},
+		{ID: missing, Result: DeleteResultNotFound},
+		{ID: int(a.ID), Result: DeleteResultDeleted},
+	})
+	for _, acc := range []*Account{a, b} {
+		if _, anonymized := anonymizedRow(t, store, acc.ID); !anonymized {
+			t.Errorf("account %d was not anonymized", acc.ID)
+		}
+	}
+}
+
+func TestAnonymizeAccountsBatchWithFundsRollsBack(t *testing.T) {
+	store := newTestStore(t)
+	empty := newTestAccount(t, store, 0)
+	funded := newTestAccount(t, store, 100)
+
+	results, err := store.AnonymizeAccounts(context.Background(), []int{int(empty.ID), int(funded.ID)})
+	if !errors.Is(err, ErrAccountHasFunds) {
+		t.Fatalf("got %v, want ErrAccountHasFunds", err)
+	}
+	checkDeleteResults(t, results, []*DeleteResult{
+		{ID: int(empty.ID), Result: DeleteResultRolledBack},
+		{ID: int(funded.ID), Result: DeleteResultHasFunds},
+	})
+	for _, acc := range []*Account{empty, funded} {
+		if _, anonymized := anonymizedRow(t, store, acc.ID); anonymized {
+			t.Errorf("account %d was anonymized despite the rollback", acc.ID)
+		}
+	}
+}
+
+func TestDeleteAccountsHandler(t *testing.T) {
+	store := newTestStore(t)
+	acc := newTestAccount(t, store, 0)
+	admin := newTestAccount(t, store, 0)
+	// withAdmin goes by the role in the token.
+	admin.Role = RoleAdmin
+
+	setJWTSecret("test-secret")
+	token, err := createJWT(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewApiServer("", store, &Config{Currency: "USD", AccountNumberFormat: AccountNumberFormatUUID})
+
+	body := strings.NewReader(fmt.Sprintf(`{"ids":[%d]}`, acc.ID))
+	req := httptest.NewRequest(http.MethodPost, "/admin/accounts/delete", body)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if _, anonymized := anonymizedRow(t, store, acc.ID); !anonymized {
+		t.Error("account was not anonymized")
+	}
+}
+
+func checkDeleteResults(t *testing.T, got, want []*DeleteResult) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != *want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, *got[i], *want[i])
+		}
+	}
+}
+
+func TestAnonymizeAccountScrubsAuditLog(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	acc := newTestAccount(t, store, 0)
+
+	event := &AuditEvent{
+		AccountID: acc.ID,
+		Action:    AuditActionLogin,
+		Outcome:   AuditOutcomeSuccess,
+		IP:        "203.0.113.7",
+		Geo:       &GeoLocation{Country: "NZ", City: "Wellington"},
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	if err := store.CreateAuditEvent(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.AnonymizeAccount(ctx, int(acc.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	var ip string
+	var located bool
+	if err := store.db.QueryRow("select ip, geo is not null from audit_log where id = $1", event.ID).Scan(&ip, &located); err != nil {
+		t.Fatal(err)
+	}
+	if ip != "" || located {
+		t.Errorf("audit event kept ip %q, geo %v", ip, located)
+	}
+}
+
+func TestPurgeClosedAccounts(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	due := newTestAccount(t, store, 0)
+	pending := newTestAccount(t, store, 0)
+
+	if _, err := store.CloseAccount(ctx, int(due.ID), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CloseAccount(ctx, int(pending.ID), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := store.PurgeClosedAccounts(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d accounts, want 1", purged)
+	}
+	if _, anonymized := anonymizedRow(t, store, due.ID); !anonymized {
+		t.Error("account past its grace period was not anonymized")
+	}
+	if _, anonymized := anonymizedRow(t, store, pending.ID); anonymized {
+		t.Error("account within its grace period was anonymized")
+	}
+	if _, err := store.RestoreAccount(ctx, int(due.ID), now); !errors.Is(err, ErrAccountNotRestorable) {
+		t.Errorf("restoring a purged account: got %v, want ErrAccountNotRestorable", err)
+	}
+
+	restored, err := store.RestoreAccount(ctx, int(pending.ID), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Status != AccountStatusActive || restored.PurgeAt != nil {
+		t.Errorf("restored account has status %q, purge_at %v", restored.Status, restored.PurgeAt)
+	}
+}
+
+func newPendingTestAccount(t testing.TB, store Storage) *Account {
+	t.Helper()
+	acc, err := NewAccount(context.Background(), "Grace", "Hopper", "correct horse battery staple", uuid.NewString(), Pepper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Status = AccountStatusPending
+	if err := store.CreateAccount(context.Background(), acc); err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+func TestDecideAccountRecordsApprover(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	admin := newTestAccount(t, store, 0)
+	acc := newPendingTestAccount(t, store)
+
+	event := &AuditEvent{ActorID: &admin.ID, Action: AuditActionAccountApprove, Outcome: AuditOutcomeSuccess, CreatedAt: NewTimestamp(time.Now())}
+	decided, err := store.DecideAccount(ctx, int(acc.ID), AccountStatusActive, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decided.Status != AccountStatusActive {
+		t.Errorf("got status %q, want %q", decided.Status, AccountStatusActive)
+	}
+
+	var actor int64
+	err = store.db.QueryRow("select actor_id from audit_log where account_id = $1 and action = $2", acc.ID, AuditActionAccountApprove).Scan(&actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actor != admin.ID {
+		t.Errorf("approval recorded actor %d, want %d", actor, admin.ID)
+	}
+}
+
+func TestDecideAccountRollsBackWithoutAudit(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	acc := newPendingTestAccount(t, store)
+
+	// No such account, so the audit row breaks its foreign key.
+	missing := acc.ID + 1000
+	event := &AuditEvent{ActorID: &missing, Action: AuditActionAccountApprove, Outcome: AuditOutcomeSuccess, CreatedAt: NewTimestamp(time.Now())}
+	if _, err := store.DecideAccount(ctx, int(acc.ID), AccountStatusActive, event); err == nil {
+		t.Fatal("decision succeeded without its audit row")
+	}
+
+	got, err := store.GetAccountByID(ctx, int(acc.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != AccountStatusPending {
+		t.Errorf("got status %q after the failed decision, want %q", got.Status, AccountStatusPending)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// Timestamp is the time type of every response field. It always encodes
+// as RFC 3339 in UTC, with a Z suffix, whatever zone the time was read or
+// made in, so the database session's time zone never shows through.
+type Timestamp struct {
+	time.Time
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{t.UTC()}
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.UTC().Format(time.RFC3339Nano) + `"`), nil
+}
+
+// Value stores the instant in UTC.
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.UTC(), nil
+}
+
+// Scan reads a timestamp column, converting it to UTC.
+func (t *Timestamp) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		t.Time = v.UTC()
+		return nil
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Timestamp", src)
+}
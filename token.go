@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How handleLogin hands out tokens: a fresh one on every login, or, for
+// chatty clients that log in over and over, the one issued moments ago.
+const (
+	LoginTokenPolicyFresh = "fresh"
+	LoginTokenPolicyReuse = "reuse"
+)
+
+// tokenTTL is how long an issued JWT stays valid.
+const tokenTTL = time.Minute
+
+// tokenCachePruneSize is how many entries the cache may hold before a put
+// sweeps out the stale ones.
+const tokenCachePruneSize = 1024
+
+type issuedToken struct {
+	token    string
+	issuedAt time.Time
+}
+
+// tokenCache remembers the last token issued to each account so logins
+// within window of it get the same token back. The cache is per process;
+// behind a load balancer each instance reuses its own tokens.
+type tokenCache struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]issuedToken
+}
+
+func newTokenCache(window time.Duration) *tokenCache {
+	return &tokenCache{
+		window: window,
+		tokens: map[string]issuedToken{},
+	}
+}
+
+// get returns the account's cached token if it was issued within the
+// window and after the token epoch, so a revoked token is never reused.
+func (c *tokenCache) get(number string, now, epoch time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tokens[number]
+	if !ok || now.Sub(t.issuedAt) >= c.window || t.issuedAt.Unix() <= epoch.Unix() {
+		return "", false
+	}
+	return t.token, true
+}
+
+func (c *tokenCache) put(number, token string, issuedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.tokens) >= tokenCachePruneSize {
+		for n, t := range c.tokens {
+			if issuedAt.Sub(t.issuedAt) >= c.window {
+				delete(c.tokens, n)
+			}
+		}
+	}
+	c.tokens[number] = issuedToken{token: token, issuedAt: issuedAt}
+}
+
+// issueToken returns the token for a successful login, reusing a recent
+// one when the reuse policy is on.
+func (s *ApiServer) issueToken(ctx context.Context, acc *Account) (string, error) {
+	if s.tokens == nil {
+		return createJWT(acc)
+	}
+
+	epoch, err := s.store.GetTokenEpoch(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if token, ok := s.tokens.get(acc.Number, now, epoch); ok {
+		return token, nil
+	}
+
+	token, err := createJWT(acc)
+	if err != nil {
+		return "", err
+	}
+	s.tokens.put(acc.Number, token, now)
+	return token, nil
+}
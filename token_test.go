@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheGet(t *testing.T) {
+	issued := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		now    time.Time
+		epoch  time.Time
+		wantOK bool
+	}{
+		{"just issued", issued, time.Time{}, true},
+		{"inside the window", issued.Add(29 * time.Second), time.Time{}, true},
+		{"window passed", issued.Add(30 * time.Second), time.Time{}, false},
+		{"epoch before issue", issued.Add(time.Second), issued.Add(-time.Second), true},
+		{"epoch in the same second", issued.Add(time.Second), issued.Add(500 * time.Millisecond), false},
+		{"epoch after issue", issued.Add(2 * time.Second), issued.Add(time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTokenCache(30 * time.Second)
+			c.put("acc-1", "token-1", issued)
+
+			token, ok := c.get("acc-1", tt.now, tt.epoch)
+			if ok != tt.wantOK || (ok && token != "token-1") {
+				t.Errorf("get = %q, %v, want ok %v", token, ok, tt.wantOK)
+			}
+			if _, ok := c.get("acc-2", tt.now, tt.epoch); ok {
+				t.Error("got a token for an account that was never issued one")
+			}
+		})
+	}
+}
+
+func TestTokenCachePrunesStaleTokens(t *testing.T) {
+	c := newTokenCache(time.Minute)
+	issued := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < tokenCachePruneSize; i++ {
+		c.put(fmt.Sprint(i), "token", issued)
+	}
+
+	c.put("fresh", "token", issued.Add(time.Minute))
+	if len(c.tokens) != 1 {
+		t.Errorf("%d tokens cached after the sweep, want 1", len(c.tokens))
+	}
+}
+
+func TestLoginReusesTokenUntilEpoch(t *testing.T) {
+	store := NewMemoryStore()
+	acc := newTestAccount(t, store, 0)
+	setJWTSecret("test-secret")
+	server := NewApiServer("", store, &Config{
+		Currency:              "USD",
+		AccountNumberFormat:   AccountNumberFormatUUID,
+		LoginTokenPolicy:      LoginTokenPolicyReuse,
+		LoginTokenReuseWindow: 30 * time.Second,
+	})
+
+	login := func() (string, time.Time) {
+		t.Helper()
+		rec := serveTest(server, http.MethodPost, "/login", "", `{"number":"`+acc.Number+`","password":"correct horse battery staple"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login: status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp LoginResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Token, server.tokens.tokens[acc.Number].issuedAt
+	}
+
+	first, firstIssued := login()
+	second, secondIssued := login()
+	if second != first || !secondIssued.Equal(firstIssued) {
+		t.Fatal("second login inside the window did not reuse the token")
+	}
+
+	// Past the epoch the cached token is revoked, so a new one is issued.
+	if _, err := store.BumpTokenEpoch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, thirdIssued := login()
+	if thirdIssued.Equal(firstIssued) {
+		t.Fatal("login after the epoch reused the revoked token")
+	}
+}
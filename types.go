@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,37 +20,317 @@ type Account struct {
 	LastName          string    `json:"last_name"`
 	Number            string    `json:"number"`
 	EncryptedPassword string    `json:"-"`
-	Balance           int       `json:"balance"`
-	CreatedAt         time.Time `json:"created_at"`
+	Balance           Money     `json:"balance"`
+	BalanceFormatted  string    `json:"balance_formatted,omitempty"`
+	Role              string    `json:"role"`
+	Type              string    `json:"account_type"`
+	Status            string    `json:"status"`
+	CreatedAt         Timestamp `json:"created_at"`
+	// PurgeAt is when a closed account is anonymized for good, unless it
+	// is restored first.
+	PurgeAt *Timestamp `json:"purge_at,omitempty"`
 }
 
-func (a *Account) ValidatePassword(pw string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(a.EncryptedPassword), []byte(pw)) == nil
+// AccountFilter picks accounts created in the half-open range
+// [CreatedFrom, CreatedTo) whose "first last" name matches NamePattern, a
+// case-insensitive SQL LIKE pattern. An empty pattern matches any name.
+type AccountFilter struct {
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	NamePattern string
 }
 
-func NewAccount(firstName, lastName, password string) (*Account, error) {
-	encpw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+const (
+	AccountTypeChecking = "checking"
+	AccountTypeSavings  = "savings"
+)
+
+// Only active accounts can log in and move money. Accounts start pending
+// when the deployment requires an admin to approve them.
+const (
+	AccountStatusActive   = "active"
+	AccountStatusPending  = "pending"
+	AccountStatusRejected = "rejected"
+	AccountStatusFrozen   = "frozen"
+	AccountStatusClosed   = "closed"
+)
+
+// Pepper is the server-side secret mixed into passwords before bcrypt, so
+// a leaked accounts table alone is not enough to brute-force passwords.
+//
+// To rotate it, move the old value to Previous and set a new Current.
+// Hashes made under Previous (or before any pepper was configured) still
+// validate, and are rehashed under Current on the account's next login.
+// Once every active account has logged in, Previous can be dropped.
+//
+// FallbackUntil, when set, closes the rotation window: after it, only
+// hashes made under Current validate, and accounts that never logged in
+// during the window have to reset their password.
+type Pepper struct {
+	Current       string
+	Previous      string
+	FallbackUntil time.Time
+}
+
+// fallbackOpen reports whether hashes made under Previous or without any
+// pepper are still accepted at now.
+func (p Pepper) fallbackOpen(now time.Time) bool {
+	return p.FallbackUntil.IsZero() || now.Before(p.FallbackUntil)
+}
+
+// ValidatePassword reports whether pw matches the stored hash. rehash is
+// true when it only matched under the previous pepper or no pepper at all,
+// meaning the hash should be replaced with one made under the current one.
+// err is set when ctx ends before a bcrypt slot frees up.
+func (a *Account) ValidatePassword(ctx context.Context, pw string, pepper Pepper) (ok bool, rehash bool, err error) {
+	if ok, err := comparePassword(ctx, a.EncryptedPassword, pw, pepper.Current); ok || err != nil {
+		return ok, false, err
+	}
+	if !pepper.fallbackOpen(time.Now()) {
+		return false, false, nil
+	}
+	if pepper.Previous != "" {
+		if ok, err := comparePassword(ctx, a.EncryptedPassword, pw, pepper.Previous); ok || err != nil {
+			return ok, ok, err
+		}
+	}
+	if pepper.Current != "" {
+		if ok, err := comparePassword(ctx, a.EncryptedPassword, pw, ""); ok || err != nil {
+			return ok, ok, err
+		}
+	}
+	return false, false, nil
+}
+
+func NewAccount(ctx context.Context, firstName, lastName, password, number string, pepper Pepper) (*Account, error) {
+	encpw, err := hashPassword(ctx, password, pepper.Current)
 	if err != nil {
 		return nil, err
 	}
 	return &Account{
 		FirstName:         firstName,
 		LastName:          lastName,
-		Number:            uuid.NewString(),
-		EncryptedPassword: string(encpw),
-		CreatedAt:         time.Now().UTC(),
+		Number:            number,
+		EncryptedPassword: encpw,
+		Role:              RoleUser,
+		Type:              AccountTypeChecking,
+		Status:            AccountStatusActive,
+		CreatedAt:         NewTimestamp(time.Now()),
 	}, nil
 }
 
+func hashPassword(ctx context.Context, pw, pepper string) (string, error) {
+	release, err := acquireBcryptSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	encpw, err := bcrypt.GenerateFromPassword(pepperPassword(pw, pepper), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(encpw), nil
+}
+
+func comparePassword(ctx context.Context, hash, pw, pepper string) (bool, error) {
+	release, err := acquireBcryptSlot(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), pepperPassword(pw, pepper)) == nil, nil
+}
+
+// pepperPassword keys an HMAC with the pepper rather than appending it:
+// bcrypt ignores everything past 72 bytes, which would silently drop the
+// pepper from long passwords. Without a pepper the password is used as is.
+func pepperPassword(pw, pepper string) []byte {
+	if pepper == "" {
+		return []byte(pw)
+	}
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(pw))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+const (
+	AuditActionLogin          = "login"
+	AuditActionAccountApprove = "account.approve"
+	AuditActionAccountReject  = "account.reject"
+	AuditActionAccountFreeze  = "account.freeze"
+	AuditActionLedgerRepair   = "ledger.repair"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeLocked  = "locked"
+)
+
+// AuditEvent records something that happened to AccountID. ActorID is the
+// account that did it when that was someone else, such as an approving
+// admin.
+type AuditEvent struct {
+	ID        int64  `json:"id"`
+	AccountID int64  `json:"-"`
+	ActorID   *int64 `json:"-"`
+	Action    string `json:"action"`
+	Outcome   string `json:"outcome"`
+	IP        string `json:"ip"`
+	// Geo is where IP was located at the time, for logins when a
+	// GeoLocator is set up.
+	Geo       *GeoLocation `json:"geo,omitempty"`
+	CreatedAt Timestamp    `json:"created_at"`
+}
+
+// ListResponse is the envelope returned by every list endpoint.
+type ListResponse struct {
+	Data   any `json:"data"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Skipped counts rows left out of Data because they could not be
+	// read, making the page partial.
+	Skipped int `json:"skipped,omitempty"`
+}
+
 type CreateAccountRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Password  string `json:"password"`
+	FirstName      string `json:"first_name"`
+	LastName       string `json:"last_name"`
+	Password       string `json:"password"`
+	InitialBalance Money  `json:"initial_balance"`
+	AccountType    string `json:"account_type"`
+}
+
+// Validate checks the fields that need no server state, and cleans the
+// names in place. The password is checked against the configured policy
+// separately.
+func (r *CreateAccountRequest) Validate() error {
+	var err error
+	if r.FirstName, err = cleanName("first_name", r.FirstName); err != nil {
+		return err
+	}
+	if r.LastName, err = cleanName("last_name", r.LastName); err != nil {
+		return err
+	}
+	if r.InitialBalance < 0 {
+		return fmt.Errorf("initial balance must not be negative")
+	}
+	switch r.AccountType {
+	case "", AccountTypeChecking, AccountTypeSavings:
+	default:
+		return fmt.Errorf("account_type must be %q or %q", AccountTypeChecking, AccountTypeSavings)
+	}
+	return nil
 }
 
+// maxNameLength caps first and last names, in characters.
+const maxNameLength = 100
+
+// cleanName strips control characters and surrounding whitespace from a
+// name, which ends up in statements and exported reports, and checks what
+// is left is neither empty nor longer than maxNameLength.
+func cleanName(field, name string) (string, error) {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" {
+		return "", fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return "", fmt.Errorf("%s must be at most %d characters", field, maxNameLength)
+	}
+	return name, nil
+}
+
+// TransferRequest moves Amount, in the same integer units as balances, from
+// the caller's account to the destination, named either by account number
+// in ToAccount or by the id of one of the sender's saved beneficiaries in
+// BeneficiaryID.
 type TransferRequest struct {
-	ToAccount string  `json:"to_account"`
-	Amount    float64 `json:"amount"`
+	ToAccount     string `json:"to_account"`
+	BeneficiaryID int64  `json:"beneficiary_id"`
+	Amount        Money  `json:"amount"`
+	// Category tags both sides of the transfer for per-category totals,
+	// such as "rent" or "salary".
+	Category string `json:"category"`
+	// Metadata is stored with the transfer and returned in transaction
+	// listings, which can be filtered by it.
+	Metadata Metadata `json:"metadata"`
+}
+
+// Validate checks the fields that need no server state. The destination
+// is checked once a beneficiary_id has been resolved to a number.
+func (r *TransferRequest) Validate() error {
+	if r.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if r.BeneficiaryID < 0 {
+		return fmt.Errorf("beneficiary_id must be positive")
+	}
+	if r.BeneficiaryID != 0 && r.ToAccount != "" {
+		return fmt.Errorf("give either to_account or beneficiary_id, not both")
+	}
+	if err := validateCategory(r.Category); err != nil {
+		return err
+	}
+	return r.Metadata.Validate()
+}
+
+// TransferResult describes a completed transfer. TransactionID is the id
+// shared by both of its ledger lines, and Balance is the sender's balance
+// right after it.
+type TransferResult struct {
+	TransactionID string    `json:"transaction_id"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Amount        Money     `json:"amount"`
+	Category      string    `json:"category,omitempty"`
+	Balance       Money     `json:"balance"`
+	CreatedAt     Timestamp `json:"created_at"`
+}
+
+// FreezeRequest is the AccountFilter of POST /admin/freeze, of which at
+// least one criterion is required. DryRun only counts the matches.
+type FreezeRequest struct {
+	CreatedFrom *time.Time `json:"created_from"`
+	CreatedTo   *time.Time `json:"created_to"`
+	NamePattern string     `json:"name_pattern"`
+	DryRun      bool       `json:"dry_run"`
+}
+
+// maxDeleteBatch caps how many accounts one batch delete may close.
+const maxDeleteBatch = 100
+
+type DeleteAccountsRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// What a batch delete did with one account. rolled_back marks accounts
+// that could have been closed but were not, because another account in
+// the batch still held funds.
+const (
+	DeleteResultDeleted    = "deleted"
+	DeleteResultNotFound   = "not_found"
+	DeleteResultHasFunds   = "has_funds"
+	DeleteResultRolledBack = "rolled_back"
+)
+
+type DeleteResult struct {
+	ID     int    `json:"id"`
+	Result string `json:"result"`
+}
+
+type SweepRequest struct {
+	ToAccount string `json:"to_account"`
 }
 
 type LoginRequest struct {
@@ -51,7 +338,79 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+func (r *LoginRequest) Validate() error {
+	if r.Number == "" {
+		return fmt.Errorf("number is required")
+	}
+	if r.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+	return nil
+}
+
+type VerifyTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type VerifyTokenResponse struct {
+	Valid     bool       `json:"valid"`
+	ExpiresAt *Timestamp `json:"expires_at,omitempty"`
+}
+
+type VerifyRecipientResponse struct {
+	Exists      bool   `json:"exists"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type LoginResponse struct {
 	Number string `json:"number"`
 	Token  string `json:"token"`
 }
+
+// ServerConfigResponse describes what this server accepts, so clients can
+// adapt to it without hardcoding. It must never carry secrets.
+type ServerConfigResponse struct {
+	Currencies []Currency `json:"currencies"`
+	// MinTransferAmount and MaxTransferAmount are in minor units. A nil
+	// maximum means transfers are only limited by the sender's balance.
+	MinTransferAmount  Money          `json:"min_transfer_amount"`
+	MaxTransferAmount  *Money         `json:"max_transfer_amount"`
+	AccountTypes       []string       `json:"account_types"`
+	PasswordPolicy     PasswordPolicy `json:"password_policy"`
+	TwoFactorAvailable bool           `json:"two_factor_available"`
+	DisabledFeatures   []string       `json:"disabled_features"`
+}
+
+// Auth method names reported by GET /auth/methods.
+const (
+	AuthMethodPassword = "password"
+	AuthMethodMFA      = "mfa"
+	AuthMethodAPIKey   = "api_key"
+)
+
+type AuthMethodsResponse struct {
+	Methods []AuthMethod `json:"methods"`
+}
+
+// AuthMethod describes one way of authenticating. Endpoint is where the
+// credential is obtained and Header is where it is sent on later requests;
+// the remaining fields only apply to some methods.
+type AuthMethod struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Header   string `json:"header,omitempty"`
+	// TokenTTLSeconds is how long a token issued by Endpoint is valid.
+	TokenTTLSeconds int             `json:"token_ttl_seconds,omitempty"`
+	PasswordPolicy  *PasswordPolicy `json:"password_policy,omitempty"`
+	// MaxFailedLogins failures within LockoutSeconds lock the account out
+	// for the rest of that window. Zero means there is no lockout.
+	MaxFailedLogins int      `json:"max_failed_logins,omitempty"`
+	LockoutSeconds  int      `json:"lockout_seconds,omitempty"`
+	Scopes          []string `json:"scopes,omitempty"`
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidatePasswordPepperFallback(t *testing.T) {
+	ctx := context.Background()
+	hashUnder := func(pepper string) *Account {
+		encpw, err := hashPassword(ctx, "hunter2hunter2", pepper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Account{EncryptedPassword: encpw}
+	}
+	current := hashUnder("new")
+	previous := hashUnder("old")
+	unpeppered := hashUnder("")
+
+	open := Pepper{Current: "new", Previous: "old"}
+	closed := Pepper{Current: "new", Previous: "old", FallbackUntil: time.Now().Add(-time.Minute)}
+	later := Pepper{Current: "new", Previous: "old", FallbackUntil: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name       string
+		acc        *Account
+		pepper     Pepper
+		pw         string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"current", current, open, "hunter2hunter2", true, false},
+		{"current after window", current, closed, "hunter2hunter2", true, false},
+		{"wrong password", current, open, "hunter3hunter3", false, false},
+		{"previous", previous, open, "hunter2hunter2", true, true},
+		{"previous inside window", previous, later, "hunter2hunter2", true, true},
+		{"previous after window", previous, closed, "hunter2hunter2", false, false},
+		{"unpeppered", unpeppered, open, "hunter2hunter2", true, true},
+		{"unpeppered after window", unpeppered, closed, "hunter2hunter2", false, false},
+		{"unpeppered without pepper", unpeppered, Pepper{}, "hunter2hunter2", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := tt.acc.ValidatePassword(ctx, tt.pw, tt.pepper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("got ok=%v rehash=%v, want ok=%v rehash=%v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
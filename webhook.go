@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	WebhookEventTransferCompleted = "transfer.completed"
+	WebhookEventAccountCreated    = "account.created"
+	WebhookEventAccountDeleted    = "account.deleted"
+	WebhookEventAccountFrozen     = "account.frozen"
+)
+
+const (
+	// webhookBatchSize caps how many outbox messages one poll delivers.
+	webhookBatchSize = 50
+	// webhookBaseBackoff doubles with every failed attempt, up to
+	// webhookMaxBackoff.
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// webhookDeliveryTimeout bounds a single delivery.
+	webhookDeliveryTimeout = 10 * time.Second
+	// webhookLease is how long a poll holds the messages it claimed. It
+	// has to outlast delivering a whole batch, or a slow receiver would
+	// see messages from one batch again from another dispatcher.
+	webhookLease = webhookBatchSize*webhookDeliveryTimeout + time.Minute
+)
+
+// OutboxMessage is a webhook event queued in the same transaction as the
+// change it describes.
+type OutboxMessage struct {
+	ID            int64           `json:"id"`
+	EventType     string          `json:"type"`
+	Payload       json.RawMessage `json:"data"`
+	Attempts      int             `json:"-"`
+	NextAttemptAt time.Time       `json:"-"`
+	CreatedAt     Timestamp       `json:"created_at"`
+}
+
+// WebhookDispatcher delivers outbox messages to the configured URL in the
+// background. Messages stay in the outbox until delivered, so events
+// survive restarts and outages of the receiver, and are only marked failed
+// once maxAttempts deliveries have been refused.
+type WebhookDispatcher struct {
+	store       Storage
+	url         string
+	interval    time.Duration
+	maxAttempts int
+	client      *http.Client
+}
+
+func NewWebhookDispatcher(store Storage, config *Config) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:       store,
+		url:         config.WebhookURL,
+		interval:    config.WebhookPollInterval,
+		maxAttempts: config.WebhookMaxAttempts,
+		client:      &http.Client{Timeout: webhookDeliveryTimeout},
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatchPending(ctx context.Context) {
+	messages, err := d.store.ClaimPendingWebhooks(ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		log.Println("failed to read webhook outbox:", err)
+		return
+	}
+
+	for _, msg := range messages {
+		if err := d.deliver(ctx, msg); err != nil {
+			if msg.Attempts+1 >= d.maxAttempts {
+				log.Printf("webhook %d delivery failed (attempt %d), giving up: %v", msg.ID, msg.Attempts+1, err)
+				if err := d.store.MarkWebhookFailed(ctx, msg.ID); err != nil {
+					log.Println("failed to mark webhook failed:", err)
+				}
+				continue
+			}
+			next := time.Now().UTC().Add(webhookBackoff(msg.Attempts + 1))
+			log.Printf("webhook %d delivery failed (attempt %d), retrying at %s: %v", msg.ID, msg.Attempts+1, next, err)
+			if err := d.store.MarkWebhookRetry(ctx, msg.ID, next); err != nil {
+				log.Println("failed to reschedule webhook:", err)
+			}
+			continue
+		}
+		if err := d.store.MarkWebhookSent(ctx, msg.ID); err != nil {
+			log.Println("failed to mark webhook sent:", err)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, msg *OutboxMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookBackoff is the delay before the given attempt number.
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, webhookBaseBackoff},
+		{2, 2 * webhookBaseBackoff},
+		{4, 8 * webhookBaseBackoff},
+		{100, webhookMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempt); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// TestConcurrentDispatchersDeliverOnce runs two dispatchers against one
+// outbox at the same time, as two instances of the server would.
+func TestConcurrentDispatchersDeliverOnce(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	const queued = 3*webhookBatchSize + 7
+	err := store.withTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for i := 0; i < queued; i++ {
+			if err := insertOutboxMessage(ctx, tx, WebhookEventAccountCreated, map[string]int{"n": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	deliveries := map[int64]int{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg OutboxMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		deliveries[msg.ID]++
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	config := &Config{WebhookURL: receiver.URL, WebhookPollInterval: time.Second, WebhookMaxAttempts: 3}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		d := NewWebhookDispatcher(store, config)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for poll := 0; poll < queued/webhookBatchSize+2; poll++ {
+				d.dispatchPending(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(deliveries) != queued {
+		t.Errorf("%d of %d messages delivered", len(deliveries), queued)
+	}
+	for id, n := range deliveries {
+		if n != 1 {
+			t.Errorf("message %d delivered %d times", id, n)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrWhitelistEntryNotFound = newStatusError(http.StatusNotFound, "whitelist entry not found")
+	ErrWhitelistEntryExists   = newStatusError(http.StatusConflict, "account number is already whitelisted")
+	ErrNotWhitelisted         = newStatusError(http.StatusForbidden, "destination is not on the account's transfer whitelist")
+)
+
+// WhitelistEntry is a destination an account restricted to its transfer
+// whitelist may still send money to. The whitelist is set by admins, since
+// it is a control over the account rather than a convenience like saved
+// beneficiaries.
+type WhitelistEntry struct {
+	ID        int64     `json:"id"`
+	AccountID int64     `json:"-"`
+	Number    string    `json:"number"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+// TransferWhitelist is an account's whitelist. Entries only restrict
+// transfers while Enabled is set.
+type TransferWhitelist struct {
+	Enabled bool              `json:"enabled"`
+	Entries []*WhitelistEntry `json:"entries"`
+}
+
+type SetWhitelistRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type AddWhitelistEntryRequest struct {
+	Number string `json:"number"`
+}
+
+func (s *ApiServer) handleGetWhitelist(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	whitelist, err := s.store.GetTransferWhitelist(r.Context(), int64(id))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, whitelist)
+}
+
+func (s *ApiServer) handleSetWhitelist(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	var req SetWhitelistRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	if err := s.store.SetTransferWhitelistEnabled(r.Context(), int64(id), req.Enabled); err != nil {
+		return err
+	}
+	whitelist, err := s.store.GetTransferWhitelist(r.Context(), int64(id))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, whitelist)
+}
+
+func (s *ApiServer) handleAddWhitelistEntry(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	var req AddWhitelistEntryRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if err := checkDestinationNumber(s.config.AccountNumberFormat, s.config.AccountNumberPrefix, req.Number); err != nil {
+		return err
+	}
+	if _, err := s.store.GetAccountByNumber(r.Context(), req.Number); err != nil {
+		return err
+	}
+
+	entry := &WhitelistEntry{
+		AccountID: int64(id),
+		Number:    req.Number,
+		CreatedAt: NewTimestamp(time.Now()),
+	}
+	if err := s.store.AddWhitelistEntry(r.Context(), entry); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusCreated, entry)
+}
+
+func (s *ApiServer) handleDeleteWhitelistEntry(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	number := mux.Vars(r)["number"]
+	if number == "" {
+		return fmt.Errorf("account number is required")
+	}
+
+	if err := s.store.DeleteWhitelistEntry(r.Context(), int64(id), number); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}